@@ -0,0 +1,243 @@
+package mt5client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditRecord describes a state-changing command sent to the server: trade
+// writes such as TRADE_BALANCE, DEALER_SEND, DEAL_DELETE, POSITION_DELETE,
+// user writes such as USER_ADD, USER_UPDATE, USER_DELETE and the other
+// commands, those sent with Pool.Do included, unless they are registered as
+// CommandClassRead, see isWrite.
+type AuditRecord struct {
+	Time time.Time `json:"time"`
+	// Caller is the identity set with WithCaller on the context of the pool,
+	// see Pool.WithContext.
+	Caller  string `json:"caller,omitempty"`
+	Command string `json:"command"`
+	// Login is the LOGIN parameter, or the Login field of the JSON payload
+	// as for DEALER_SEND.
+	Login string `json:"login,omitempty"`
+	// Params and Payload are masked by the redactor of the pool.
+	Params  map[string]string `json:"params,omitempty"`
+	Payload string            `json:"payload,omitempty"`
+	Conn    int               `json:"conn"`
+	Retcode string            `json:"retcode,omitempty"`
+	// Ticket is the deal created by TRADE_BALANCE or DEALER_SEND.
+	Ticket   string        `json:"ticket,omitempty"`
+	Duration time.Duration `json:"duration_ns"`
+	Error    string        `json:"error,omitempty"`
+	// Simulated is set for the commands not sent in dry-run mode.
+	Simulated bool `json:"simulated,omitempty"`
+}
+
+// Auditor receives the audit records. Audit is called from the connection
+// goroutines, a failure is logged.
+type Auditor interface {
+	Audit(record *AuditRecord) error
+}
+
+// AuditorFunc adapts a function to Auditor.
+type AuditorFunc func(record *AuditRecord) error
+
+func (f AuditorFunc) Audit(record *AuditRecord) error {
+	return f(record)
+}
+
+// WithAuditor sets where the audit records of the writes go, none are made
+// by default.
+func WithAuditor(a Auditor) Option {
+	return func(o *options) {
+		o.auditor = a
+	}
+}
+
+type callerKey struct{}
+
+// WithCaller returns a copy of ctx carrying the identity of the caller, such
+// as the back-office user, recorded in the audit records of the commands sent
+// by a pool using it, see Pool.WithContext.
+func WithCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+// CallerFrom returns the identity set with WithCaller, if any.
+func CallerFrom(ctx context.Context) string {
+	caller, _ := ctx.Value(callerKey{}).(string)
+	return caller
+}
+
+// JSONLinesAuditor writes every record as a line of JSON.
+type JSONLinesAuditor struct {
+	mux    sync.Mutex
+	enc    *json.Encoder
+	closer io.Closer
+}
+
+// NewJSONLinesAuditor writes the records to w.
+func NewJSONLinesAuditor(w io.Writer) *JSONLinesAuditor {
+	return &JSONLinesAuditor{enc: json.NewEncoder(w)}
+}
+
+// OpenAuditFile appends the records to the file at path, creating it if
+// needed. The file is closed by Close.
+func OpenAuditFile(path string) (*JSONLinesAuditor, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	a := NewJSONLinesAuditor(f)
+	a.closer = f
+	return a, nil
+}
+
+func (a *JSONLinesAuditor) Audit(record *AuditRecord) error {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	return a.enc.Encode(record)
+}
+
+// Close closes the file opened by OpenAuditFile.
+func (a *JSONLinesAuditor) Close() error {
+	if a.closer == nil {
+		return nil
+	}
+	return a.closer.Close()
+}
+
+// pendingAudit is the record of a DEALER_SEND completed by the
+// DEALER_UPDATES of the request id.
+type pendingAudit struct {
+	id     string
+	record *AuditRecord
+}
+
+// auditExchange makes the audit record of every write exchanged with the
+// server. The record of DEALER_SEND is made once its DEALER_UPDATES follow-up
+// returned the deal.
+func (c *MT5Client) auditExchange(ctx context.Context, cmd *MT5Command, next Invoker) (*MT5Command, error) {
+	start := c.clock.Now()
+	resp, err := next(ctx, cmd)
+
+	if cmd.Name == MT5CommandDealerUpdates {
+		if p := c.dealerAudit; p != nil && p.id == cmd.Params["ID"] {
+			c.dealerAudit = nil
+			c.completeDealerAudit(p, resp, err)
+		}
+		return resp, err
+	}
+	if !isWrite(cmd.Name) {
+		return resp, err
+	}
+
+	r := c.auditRecord(ctx, cmd, start, resp, err)
+	if cmd.Name == MT5CommandDealerSend && err == nil {
+		req := struct {
+			Id string `json:"id"`
+		}{}
+		if json.Unmarshal([]byte(resp.Payload), &req) == nil && req.Id != "" {
+			if p := c.dealerAudit; p != nil {
+				c.emitAudit(p.record)
+			}
+			c.dealerAudit = &pendingAudit{id: req.Id, record: r}
+			return resp, err
+		}
+	}
+	c.emitAudit(r)
+	return resp, err
+}
+
+// completeDealerAudit sets the deal of the DEALER_UPDATES result, or the
+// error of the follow-up, on the record of the DEALER_SEND and emits it.
+func (c *MT5Client) completeDealerAudit(p *pendingAudit, resp *MT5Command, err error) {
+	r := p.record
+	r.Duration = c.clock.Now().Sub(r.Time)
+	if err != nil {
+		r.Error = err.Error()
+		c.emitAudit(r)
+		return
+	}
+
+	updates := make(map[string][]*DealerUpdates, 1)
+	if err := json.Unmarshal([]byte(resp.Payload), &updates); err != nil {
+		r.Error = fmt.Sprintf("%s response unmarshal error: %v", MT5CommandDealerUpdates, err)
+	}
+	for _, u := range updates[p.id] {
+		if u.Result != nil {
+			r.Ticket = u.Result.DealId
+		}
+	}
+	c.emitAudit(r)
+}
+
+// auditRecord makes the audit record of an exchange.
+func (c *MT5Client) auditRecord(ctx context.Context, cmd *MT5Command, start time.Time, resp *MT5Command, err error) *AuditRecord {
+	// Empty parameters are not sent, see encodeCommand.
+	params := make(map[string]string, len(cmd.Params))
+	for k, v := range cmd.Params {
+		if v != "" {
+			params[k] = v
+		}
+	}
+
+	r := &AuditRecord{
+		Time:     start,
+		Caller:   CallerFrom(ctx),
+		Command:  cmd.Name,
+		Login:    auditLogin(cmd),
+		Params:   c.redactor.commandParams(cmd.Name, params),
+		Payload:  c.redactor.commandPayload(cmd.Name, cmd.Payload),
+		Conn:     c.clientId,
+		Duration: c.clock.Now().Sub(start),
+	}
+	if resp != nil {
+		r.Retcode = resp.Params[MT5RetCode]
+		r.Ticket = resp.Params["TICKET"]
+		r.Simulated = Simulated(resp)
+	}
+	var retcodeErr *RetcodeError
+	if errors.As(err, &retcodeErr) {
+		r.Retcode = retcodeErr.Retcode
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// auditLogin returns the LOGIN parameter of the command, or the Login field
+// of its JSON payload.
+func auditLogin(cmd *MT5Command) string {
+	if login := cmd.Params["LOGIN"]; login != "" {
+		return login
+	}
+	req := struct {
+		Login json.RawMessage `json:"Login"`
+	}{}
+	if json.Unmarshal([]byte(cmd.Payload), &req) != nil {
+		return ""
+	}
+	// The login is sent as a string or as a number.
+	return strings.Trim(string(req.Login), `"`)
+}
+
+// isWrite reports whether the command may change the state of the server:
+// every command but the ones registered as CommandClassRead, the unregistered
+// ones included, see RegisterCommand.
+func isWrite(name string) bool {
+	return ClassOf(name) != CommandClassRead
+}
+
+func (c *MT5Client) emitAudit(r *AuditRecord) {
+	if err := c.auditor.Audit(r); err != nil {
+		c.log.Errorf("#%d %s audit failed: %v", c.clientId, r.Command, err)
+	}
+}
@@ -0,0 +1,70 @@
+package mt5client
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestAuditWrites(t *testing.T) {
+	s := newFakeServer(t, func(cmd *MT5Command) string {
+		switch cmd.Name {
+		case MT5CommandDealerSend:
+			return "DEALER_SEND|RETCODE=0 Done|\r\n{\"id\":\"7\"}"
+		case MT5CommandDealerUpdates:
+			return "DEALER_UPDATES|RETCODE=0 Done|\r\n{\"7\":[{\"result\":{\"ID\":\"7\",\"DealID\":\"555\",\"Retcode\":\"10009\"}}]}"
+		}
+		return cmd.Name + "|RETCODE=0 Done|\r\n{}"
+	})
+	// A read registered by the application is not audited, unregistered
+	// commands are.
+	RegisterCommand("SYMBOL_NEXT", CommandSpec{Class: CommandClassRead})
+	t.Cleanup(func() {
+		commandsMux.Lock()
+		delete(commands, "SYMBOL_NEXT")
+		commandsMux.Unlock()
+	})
+
+	var mux sync.Mutex
+	records := make(map[string]*AuditRecord)
+	auditor := AuditorFunc(func(r *AuditRecord) error {
+		mux.Lock()
+		defer mux.Unlock()
+		records[r.Command] = r
+		return nil
+	})
+	pool, err := New(s.config(), WithAuditor(auditor))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	if _, err := pool.CreateEmptyDeal("1000", "comment"); err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithCaller(context.Background(), "operator")
+	for _, name := range []string{"GROUP_ADD", "GROUP_GET", "SYMBOL_NEXT", MT5CommandMailSend} {
+		if _, err := pool.Do(ctx, name, nil, []byte(`{"Login":1001}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := pool.GetCommon(); err != nil {
+		t.Fatal(err)
+	}
+
+	mux.Lock()
+	defer mux.Unlock()
+	if r := records[MT5CommandDealerSend]; r == nil || r.Login != "1000" || r.Ticket != "555" {
+		t.Errorf("DEALER_SEND record %+v, want login 1000 and ticket 555", r)
+	}
+	for _, name := range []string{"GROUP_ADD", "GROUP_GET", MT5CommandMailSend} {
+		if r := records[name]; r == nil || r.Login != "1001" || r.Caller != "operator" {
+			t.Errorf("%s record %+v, want login 1001 by operator", name, r)
+		}
+	}
+	for _, name := range []string{"SYMBOL_NEXT", MT5CommandDealerUpdates, MT5CommandCommonGet} {
+		if r := records[name]; r != nil {
+			t.Errorf("query %s audited: %+v", name, r)
+		}
+	}
+}
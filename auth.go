@@ -1,50 +1,62 @@
 package mt5client
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"fmt"
 	"golang.org/x/text/encoding/unicode"
+	"net"
 )
 
-func (c *MT5Client) auth() error {
+func (c *MT5Client) auth(ctx context.Context, conn net.Conn) error {
 	authStart, body, err := c.authStartRequest()
 	if err != nil {
 		return err
 	}
 
-	c.log.Debugf("Auth start header: %s body: %s", authStart[:MT5HeaderLength], body)
+	c.log.Debugf("Auth start header: %s body: %s", authStart[:MT5HeaderLength], c.redactor.body(body))
 
-	cmd, err := c.sendRequest(authStart)
+	cmd, err := c.authRequest(ctx, conn, authStart)
 	if err != nil {
 		return err
 	}
 
-	if cmd.Params[MT5RetCode] != MT5RetCodeSuccess {
-		return fmt.Errorf("auth retcode error: %s", cmd.Params[MT5RetCode])
-	}
-
-	c.log.Debugf("Auth start response: %+v", cmd)
+	c.log.Debugf("Auth start response: %s %s", cmd.Name, c.redactor.params(cmd.Name, cmd.Params))
 
 	authAnswer, body, err := c.authAnswerRequest(cmd)
 	if err != nil {
 		return err
 	}
 
-	c.log.Debugf("Auth answer header: %s body: %s", authAnswer[:MT5HeaderLength], body)
+	c.log.Debugf("Auth answer header: %s body: %s", authAnswer[:MT5HeaderLength], c.redactor.body(body))
 
-	cmd, err = c.sendRequest(authAnswer)
+	cmd, err = c.authRequest(ctx, conn, authAnswer)
 	if err != nil {
 		return err
 	}
 
-	if cmd.Params[MT5RetCode] != MT5RetCodeSuccess {
-		return fmt.Errorf("auth retcode error: %s", cmd.Params[MT5RetCode])
+	c.log.Debugf("Auth answer response: %s %s", cmd.Name, c.redactor.params(cmd.Name, cmd.Params))
+
+	return nil
+}
+
+func (c *MT5Client) authRequest(ctx context.Context, conn net.Conn, request []byte) (*MT5Command, error) {
+	body, err := c.roundTrip(ctx, conn, request, &wireStats{})
+	if err != nil {
+		return nil, err
 	}
 
-	c.log.Debugf("Auth answer response: %+v", cmd)
+	cmd, err := parseBody(body)
+	if err != nil {
+		return nil, err
+	}
 
-	return nil
+	if cmd.Params[MT5RetCode] != MT5RetCodeSuccess {
+		return nil, &AuthError{Retcode: cmd.Params[MT5RetCode]}
+	}
+
+	return cmd, nil
 }
 
 func (c *MT5Client) authStartRequest() ([]byte, string, error) {
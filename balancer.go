@@ -0,0 +1,155 @@
+package mt5client
+
+import (
+	"sync/atomic"
+)
+
+type CommandClass int
+
+const (
+	CommandClassRead CommandClass = iota
+	CommandClassTradeWrite
+	CommandClassUserWrite
+	CommandClassOther
+)
+
+// ClassOf returns the class of an MT5 command, as registered with
+// RegisterCommand. Unknown commands belong to CommandClassOther.
+func ClassOf(command string) CommandClass {
+	if spec, ok := lookupCommand(command); ok {
+		return spec.Class
+	}
+	return CommandClassOther
+}
+
+func (c CommandClass) String() string {
+	switch c {
+	case CommandClassRead:
+		return "read"
+	case CommandClassTradeWrite:
+		return "trade_write"
+	case CommandClassUserWrite:
+		return "user_write"
+	}
+	return "other"
+}
+
+// Balancer chooses the pool connection for a command. Pick returns the index
+// in conns of the chosen connection and is called concurrently.
+type Balancer interface {
+	Pick(class CommandClass, conns []ConnHealth) int
+}
+
+type roundRobin struct {
+	next uint64
+}
+
+// RoundRobin uses the connections in turn regardless of their state.
+func RoundRobin() Balancer {
+	return &roundRobin{}
+}
+
+func (b *roundRobin) Pick(_ CommandClass, conns []ConnHealth) int {
+	n := atomic.AddUint64(&b.next, 1) - 1
+	return int(n % uint64(len(conns)))
+}
+
+type leastInFlight struct {
+	next uint64
+}
+
+// LeastInFlight uses the connection with the fewest commands in flight,
+// taking turns between equally loaded connections.
+func LeastInFlight() Balancer {
+	return &leastInFlight{}
+}
+
+func (b *leastInFlight) Pick(_ CommandClass, conns []ConnHealth) int {
+	start := int((atomic.AddUint64(&b.next, 1) - 1) % uint64(len(conns)))
+	best := start
+	for i := 1; i < len(conns); i++ {
+		j := (start + i) % len(conns)
+		if conns[j].InFlight < conns[best].InFlight {
+			best = j
+		}
+	}
+	return best
+}
+
+type skipUnhealthy struct {
+	next Balancer
+}
+
+// SkipUnhealthy passes only the connected connections to next. If none is
+// connected all of them are passed, the command then fails on its connection.
+func SkipUnhealthy(next Balancer) Balancer {
+	return &skipUnhealthy{next: next}
+}
+
+func (b *skipUnhealthy) Pick(class CommandClass, conns []ConnHealth) int {
+	return pickAmong(b.next, class, conns, func(c *ConnHealth) bool {
+		return c.State == StateConnected
+	})
+}
+
+type dedicated struct {
+	classes  map[CommandClass]map[int]struct{}
+	reserved map[int]struct{}
+	next     Balancer
+}
+
+// Dedicated reserves connections, by client id, for command classes. A class
+// with reserved connections only uses them, the other classes use the
+// connections that are not reserved. next chooses among the candidates. The
+// client ids are the slots 0 to MT5PoolSize-1 of the pool: a connection
+// replacing a closed one gets the lowest free slot, so the reservations hold
+// across resizes.
+func Dedicated(classes map[CommandClass][]int, next Balancer) Balancer {
+	b := &dedicated{
+		classes:  make(map[CommandClass]map[int]struct{}, len(classes)),
+		reserved: make(map[int]struct{}),
+		next:     next,
+	}
+	for class, ids := range classes {
+		b.classes[class] = make(map[int]struct{}, len(ids))
+		for _, id := range ids {
+			b.classes[class][id] = struct{}{}
+			b.reserved[id] = struct{}{}
+		}
+	}
+	return b
+}
+
+func (b *dedicated) Pick(class CommandClass, conns []ConnHealth) int {
+	if ids, ok := b.classes[class]; ok {
+		return pickAmong(b.next, class, conns, func(c *ConnHealth) bool {
+			_, ok := ids[c.ClientId]
+			return ok
+		})
+	}
+	return pickAmong(b.next, class, conns, func(c *ConnHealth) bool {
+		_, ok := b.reserved[c.ClientId]
+		return !ok
+	})
+}
+
+// pickAmong lets next choose among the connections matching filter, or among
+// all of them if none matches.
+func pickAmong(next Balancer, class CommandClass, conns []ConnHealth, filter func(c *ConnHealth) bool) int {
+	candidates := make([]ConnHealth, 0, len(conns))
+	indexes := make([]int, 0, len(conns))
+	for i := range conns {
+		if filter(&conns[i]) {
+			candidates = append(candidates, conns[i])
+			indexes = append(indexes, i)
+		}
+	}
+	if len(candidates) == 0 {
+		return next.Pick(class, conns)
+	}
+	i := next.Pick(class, candidates)
+	if i < 0 || i >= len(indexes) {
+		return -1
+	}
+	return indexes[i]
+}
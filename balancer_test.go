@@ -0,0 +1,43 @@
+package mt5client
+
+import "testing"
+
+func TestBalancers(t *testing.T) {
+	conns := []ConnHealth{
+		{ClientId: 0, State: StateConnected, InFlight: 3},
+		{ClientId: 1, State: StateReconnecting, InFlight: 0},
+		{ClientId: 2, State: StateConnected, InFlight: 1},
+		{ClientId: 3, State: StateConnected, InFlight: 2},
+	}
+
+	rr := RoundRobin()
+	for i := 0; i < 8; i++ {
+		if got := rr.Pick(CommandClassRead, conns); got != i%len(conns) {
+			t.Errorf("RoundRobin pick %d returned %d", i, got)
+		}
+	}
+	if got := LeastInFlight().Pick(CommandClassRead, conns); got != 1 {
+		t.Errorf("LeastInFlight picked %d, want the idle 1", got)
+	}
+	if got := SkipUnhealthy(LeastInFlight()).Pick(CommandClassRead, conns); got != 2 {
+		t.Errorf("SkipUnhealthy picked %d, want 2, the least busy connected", got)
+	}
+	down := []ConnHealth{{ClientId: 0, State: StateReconnecting}, {ClientId: 1, State: StateFailed}}
+	if got := SkipUnhealthy(RoundRobin()).Pick(CommandClassRead, down); got != 0 {
+		t.Errorf("SkipUnhealthy picked %d without connected connections, want 0", got)
+	}
+
+	b := Dedicated(map[CommandClass][]int{CommandClassTradeWrite: {0, 3}}, LeastInFlight())
+	for i := 0; i < 4; i++ {
+		if got := b.Pick(CommandClassTradeWrite, conns); got != 3 {
+			t.Errorf("trade write picked %d, want the reserved 3", got)
+		}
+		if got := b.Pick(CommandClassRead, conns); got != 1 {
+			t.Errorf("read picked %d, want the unreserved 1", got)
+		}
+	}
+	// A class falls back to every connection if its own are gone.
+	if got := b.Pick(CommandClassTradeWrite, conns[1:3]); got != 0 {
+		t.Errorf("trade write picked %d without reserved connections, want 0", got)
+	}
+}
@@ -0,0 +1,219 @@
+package mt5client
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrTimeout is returned when no response arrived within
+	// MT5RequestTimeout.
+	ErrTimeout = errors.New("timeout expired")
+	// ErrCircuitOpen matches the *CircuitOpenError of every class.
+	ErrCircuitOpen = errors.New("mt5 circuit breaker is open")
+)
+
+// TransportError is returned when a command could not be exchanged with the
+// server because the connection was not available or broke.
+type TransportError struct {
+	Err error
+	// Sent reports whether the request may have reached the server before
+	// the connection broke.
+	Sent bool
+}
+
+func (e *TransportError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// CircuitOpenError is returned without contacting the server while the
+// circuit breaker of the command class is open.
+type CircuitOpenError struct {
+	Class CommandClass
+	// RetryAfter is the time left until a probe command is let through.
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("mt5 circuit breaker for %s commands is open, retry after %s", e.Class, e.RetryAfter.Round(time.Second))
+}
+
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// Breaker configures the circuit breaker of a command class. After Failures
+// consecutive timeouts or transport errors the breaker opens and the commands
+// of the class fail right away with a *CircuitOpenError. Once OpenTimeout has
+// passed a single probe command is let through: its success closes the
+// breaker, its failure opens it again.
+type Breaker struct {
+	// Failures trips the breaker, 0 disables it.
+	Failures    int
+	OpenTimeout time.Duration
+}
+
+// DefaultBreaker is used for trade and user writes unless changed with
+// WithBreaker.
+var DefaultBreaker = Breaker{
+	Failures:    5,
+	OpenTimeout: 30 * time.Second,
+}
+
+// WithBreaker sets the circuit breaker of a command class, a zero Breaker
+// disables it.
+func WithBreaker(class CommandClass, b Breaker) Option {
+	return func(o *options) {
+		o.breakers[class] = b
+	}
+}
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("BreakerState(%d)", int(s))
+}
+
+type outcome int
+
+const (
+	outcomeSuccess outcome = iota
+	outcomeFailure
+	// outcomeNone is reported by commands that never reached the server.
+	outcomeNone
+)
+
+type breaker struct {
+	class CommandClass
+	cfg   Breaker
+	clock Clock
+	log   Logger
+
+	mux      sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newBreakers(o *options) map[CommandClass]*breaker {
+	breakers := make(map[CommandClass]*breaker, len(o.breakers))
+	for class, cfg := range o.breakers {
+		if cfg.Failures <= 0 {
+			continue
+		}
+		breakers[class] = &breaker{
+			class: class,
+			cfg:   cfg,
+			clock: o.clock,
+			log:   o.log,
+		}
+	}
+	return breakers
+}
+
+// allow returns a *CircuitOpenError unless a command may be sent.
+func (b *breaker) allow() error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		left := b.openedAt.Add(b.cfg.OpenTimeout).Sub(b.clock.Now())
+		if left > 0 {
+			return &CircuitOpenError{Class: b.class, RetryAfter: left}
+		}
+		b.setState(BreakerHalfOpen)
+		b.probing = true
+	case BreakerHalfOpen:
+		if b.probing {
+			return &CircuitOpenError{Class: b.class}
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+func (b *breaker) record(o outcome) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	switch o {
+	case outcomeSuccess:
+		b.failures = 0
+		if b.state == BreakerHalfOpen {
+			b.probing = false
+			b.setState(BreakerClosed)
+		}
+	case outcomeFailure:
+		b.failures++
+		if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= b.cfg.Failures) {
+			b.probing = false
+			b.openedAt = b.clock.Now()
+			b.setState(BreakerOpen)
+		}
+	case outcomeNone:
+		if b.state == BreakerHalfOpen {
+			b.probing = false
+		}
+	}
+}
+
+func (b *breaker) setState(state BreakerState) {
+	if b.state == state {
+		return
+	}
+	b.log.Warnf("MT5 circuit breaker for %s commands %s -> %s", b.class, b.state, state)
+	b.state = state
+}
+
+// outcomeOf tells a server that failed to answer from one that answered,
+// even with an error retcode.
+func outcomeOf(err error) outcome {
+	var transportErr *TransportError
+	if errors.Is(err, ErrTimeout) || errors.As(err, &transportErr) {
+		return outcomeFailure
+	}
+	return outcomeSuccess
+}
+
+// watchTimeout reports a failure to the breaker of the message if it is not
+// handled within timeout. It only reports the first outcome of a message.
+func (m *ClientControlMessage) watchTimeout(clock Clock, timeout time.Duration) {
+	handled := make(chan struct{})
+	m.handled = handled
+	go func() {
+		select {
+		case <-handled:
+		case <-clock.After(timeout):
+			m.report(outcomeFailure)
+		}
+	}()
+}
+
+func (m *ClientControlMessage) report(o outcome) {
+	if m.breaker != nil && atomic.CompareAndSwapInt32(&m.reported, 0, 1) {
+		m.breaker.record(o)
+	}
+}
@@ -0,0 +1,99 @@
+package mt5client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreakerStates(t *testing.T) {
+	clock := &testClock{}
+	b := &breaker{
+		class: CommandClassTradeWrite,
+		cfg:   Breaker{Failures: 3, OpenTimeout: time.Minute},
+		clock: clock,
+		log:   NopLogger(),
+	}
+
+	b.record(outcomeFailure)
+	b.record(outcomeFailure)
+	b.record(outcomeSuccess)
+	b.record(outcomeFailure)
+	b.record(outcomeFailure)
+	if err := b.allow(); err != nil || b.state != BreakerClosed {
+		t.Fatalf("breaker %s after failures broken by a success: %v", b.state, err)
+	}
+	b.record(outcomeFailure)
+	err := b.allow()
+	var openErr *CircuitOpenError
+	if !errors.As(err, &openErr) || !errors.Is(err, ErrCircuitOpen) || openErr.RetryAfter <= 0 {
+		t.Fatalf("breaker after 3 failures returned %v, want a *CircuitOpenError", err)
+	}
+
+	// A failed probe opens the breaker again.
+	clock.Advance(time.Minute)
+	if err := b.allow(); err != nil || b.state != BreakerHalfOpen {
+		t.Fatalf("breaker %s after the open timeout: %v", b.state, err)
+	}
+	if err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("second command during the probe returned %v", err)
+	}
+	b.record(outcomeFailure)
+	if err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("breaker after a failed probe returned %v", err)
+	}
+
+	// A probe that never reached the server lets another one through.
+	clock.Advance(time.Minute)
+	if err := b.allow(); err != nil {
+		t.Fatal(err)
+	}
+	b.record(outcomeNone)
+	if err := b.allow(); err != nil {
+		t.Fatalf("second probe returned %v", err)
+	}
+	b.record(outcomeSuccess)
+	if b.state != BreakerClosed {
+		t.Errorf("breaker %s after a successful probe, want closed", b.state)
+	}
+}
+
+func TestPoolBreaker(t *testing.T) {
+	s := droppingServer(t)
+	clock := &testClock{}
+	pool, err := New(s.config(),
+		WithClock(clock),
+		WithRetryPolicy(RetryPolicy{}),
+		WithBreaker(CommandClassOther, Breaker{Failures: 1, OpenTimeout: time.Minute}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	events, cancel := pool.Subscribe()
+	defer cancel()
+
+	if _, err := pool.Do(context.Background(), "DROP_GET", nil, nil); errors.Is(err, ErrCircuitOpen) || err == nil {
+		t.Fatalf("DROP_GET returned %v, want the error of the connection", err)
+	}
+	waitState(t, events, StateReconnecting)
+	waitState(t, events, StateConnected)
+
+	if _, err := pool.Do(context.Background(), "OTHER_GET", nil, nil); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("command with the breaker open returned %v", err)
+	}
+	if n := s.count("OTHER_GET"); n != 0 {
+		t.Errorf("%d OTHER_GET sent with the breaker open", n)
+	}
+	// The breaker of a class does not stop the others.
+	if _, err := pool.GetCommon(); err != nil {
+		t.Errorf("read with the breaker of other commands open: %v", err)
+	}
+
+	clock.Advance(time.Minute)
+	for i := 0; i < 2; i++ {
+		if _, err := pool.Do(context.Background(), "OTHER_GET", nil, nil); err != nil {
+			t.Errorf("command %d after the open timeout: %v", i, err)
+		}
+	}
+}
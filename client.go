@@ -2,38 +2,107 @@ package mt5client
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"github.com/IT-Kungfu/logger"
 	"net"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type MT5Client struct {
+	// inFlight is first to keep it 64-bit aligned for atomic access.
+	inFlight int64
+
 	cfg       *Config
-	log       *logger.Logger
-	conn      *net.TCPConn
+	log       Logger
+	redactor  *Redactor
+	dialer    Dialer
+	clock     Clock
+	metrics   Metrics
+	tracer    Tracer
+	auditor   Auditor
+	conn      net.Conn
 	connMux   sync.Mutex
 	controlCh chan *ClientControlMessage
 	clientId  int
+	// invoke sends commands through the interceptors.
+	invoke Invoker
+	// current is the message being handled, only used by the loop
+	// goroutine.
+	current *ClientControlMessage
+	// dealerAudit is the audit record of the DEALER_SEND waiting for its
+	// DEALER_UPDATES, only used by the loop goroutine.
+	dealerAudit *pendingAudit
+
+	stateMux     sync.Mutex
+	state        ConnState
+	reconnecting bool
+	failErr      error
+	done         chan struct{}
+	doneOnce     sync.Once
+	events       chan<- *ConnStateEvent
+
+	lastSuccess time.Time
+	lastErr     error
+	lastErrTime time.Time
+	reconnects  int
 }
 
+// NewMT5Client connects a single client reading commands from the
+// "controlCh" chan *ClientControlMessage found in the "services" map of ctx,
+// along with "mt5cfg" *Config, "log" printf-style logger and "clientId" int.
+//
+// Deprecated: use New, the pool creates its clients.
 func NewMT5Client(ctx context.Context) (*MT5Client, error) {
-	services := ctx.Value("services").(map[string]interface{})
-	c := &MT5Client{
-		cfg:       services["mt5cfg"].(*Config),
-		log:       services["log"].(*logger.Logger),
-		controlCh: services["controlCh"].(chan *ClientControlMessage),
-		clientId:  services["clientId"].(int),
+	services, ok := ctx.Value("services").(map[string]interface{})
+	if !ok {
+		return nil, errors.New("mt5client: context has no services map")
+	}
+	cfg, ok := services["mt5cfg"].(*Config)
+	if !ok || cfg == nil {
+		return nil, errors.New("mt5client: services have no mt5cfg *Config")
+	}
+	log, ok := services["log"].(Printf)
+	if !ok || log == nil {
+		return nil, errors.New("mt5client: services have no printf-style log")
+	}
+	controlCh, ok := services["controlCh"].(chan *ClientControlMessage)
+	if !ok || controlCh == nil {
+		return nil, errors.New("mt5client: services have no controlCh chan *ClientControlMessage")
 	}
+	clientId, ok := services["clientId"].(int)
+	if !ok {
+		return nil, errors.New("mt5client: services have no clientId int")
+	}
+	events, _ := services["events"].(chan *ConnStateEvent)
 
-	err := c.init()
-	if err != nil {
+	if err := cfg.Validate(); err != nil {
 		return nil, err
 	}
+	return newMT5Client(ctx, clientId, cfg, newOptions([]Option{WithLogger(PrintfLogger(log))}), controlCh, events)
+}
 
-	err = c.auth()
-	if err != nil {
+// newMT5Client connects and authenticates, ctx cancels the connection setup.
+func newMT5Client(ctx context.Context, id int, cfg *Config, o *options, controlCh chan *ClientControlMessage, events chan<- *ConnStateEvent) (*MT5Client, error) {
+	c := &MT5Client{
+		cfg:       cfg,
+		log:       o.log.With(Fields{FieldConn: id}),
+		redactor:  o.redactor,
+		dialer:    o.dialer,
+		clock:     o.clock,
+		metrics:   o.metrics,
+		tracer:    o.tracer,
+		auditor:   o.auditor,
+		controlCh: controlCh,
+		clientId:  id,
+		done:      make(chan struct{}),
+		events:    events,
+	}
+	c.invoke = c.newInvoker(o)
+
+	if err := c.connect(ctx); err != nil {
 		return nil, err
 	}
 
@@ -47,117 +116,166 @@ func (c *MT5Client) loop() {
 	for {
 		select {
 		case m := <-c.controlCh:
-			if c.commandHandler(m) {
+			if m.isAbandoned() {
+				c.log.Debugf("#%d %s abandoned, not sent", c.clientId, m.Cmd.Name)
+				m.report(outcomeNone)
+				m.finish(ErrAbandoned)
+				atomic.AddInt64(&c.inFlight, -1)
+				continue
+			}
+			c.current = m
+			stop := c.watchAbandoned(m)
+			quit := c.commandHandler(m)
+			stop()
+			c.current = nil
+			m.finish(nil)
+			if quit {
 				return
 			}
-		case <-time.After(time.Duration(c.cfg.MT5PingTimeout) * time.Second):
+			atomic.AddInt64(&c.inFlight, -1)
+		case <-c.clock.After(time.Duration(c.cfg.MT5PingTimeout) * time.Second):
 		}
 	}
 }
 
+// commandHandler executes the message as declared by the spec registered for
+// its command and reports whether the connection quits.
 func (c *MT5Client) commandHandler(m *ClientControlMessage) bool {
-	switch m.Cmd.Name {
-	case MT5CommandQuit:
+	if m.Cmd.Name == MT5CommandQuit {
 		c.quit(m)
 		return true
-	case MT5CommandOrderGetTotal:
-		c.getOrdersTotal(m)
-	case MT5CommandOrderGetHistoryTotal:
-		c.getOrdersTotal(m)
-	case MT5CommandOrderGetPage:
-		c.getOrders(m)
-	case MT5CommandOrderGetBatch:
-		c.getOrders(m)
-	case MT5CommandOrderGetHistoryPage:
-		c.getOrders(m)
-	case MT5CommandOrderGetHistoryBatch:
-		c.getOrders(m)
-	case MT5CommandDealGetTotal:
-		c.getDealsTotal(m)
-	case MT5CommandDealGetPage:
-		c.getDeals(m)
-	case MT5CommandDealGetBatch:
-		c.getDeals(m)
-	case MT5CommandDealDelete:
-		c.deleteDeals(m)
-	case MT5CommandPositionGetTotal:
-		c.getPositionsTotal(m)
-	case MT5CommandPositionGetPage:
-		c.getPositionsTotal(m)
-	case MT5CommandPositionGetBatch:
-		c.getPositions(m)
-	case MT5CommandPositionDelete:
-		c.deletePositions(m)
-	case MT5CommandClientGetIds:
-		c.getClients(m)
-	case MT5CommandUserGet:
-		c.getUser(m)
-	case MT5CommandUserGetBatch:
-		c.getUsersBatch(m)
-	case MT5CommandUserAdd:
-		c.addUpdateUser(m)
-	case MT5CommandUserUpdate:
-		c.addUpdateUser(m)
-	case MT5CommandUserDelete:
-		c.deleteUser(m)
-	case MT5CommandUserAccountGetBatch:
-		c.getUserAccounts(m)
-	case MT5CommandTradeBalance:
-		c.balance(m)
-	case MT5CommandTickGetHistory:
-		c.getTickHistory(m)
-	case MT5CommandChartGet:
-		c.getChart(m)
-	case MT5CommandDealerSend:
-		c.sendDealer(m)
 	}
+
+	spec, ok := lookupCommand(m.Cmd.Name)
+	if m.raw {
+		spec = CommandSpec{Class: spec.Class, Decode: decodeResponse}
+	} else if !ok {
+		c.respond(m, &ClientResponse{Cmd: m.Cmd, Err: fmt.Errorf("%s: %w", m.Cmd.Name, ErrUnknownCommand)})
+		return false
+	}
+	c.execute(m, spec)
 	return false
 }
 
-func (c *MT5Client) init() error {
-	c.log.Infof("Connecting to MT5 server %s:%d", c.cfg.MT5Host, c.cfg.MT5Port)
-
-	mt5Addr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf("%s:%d", c.cfg.MT5Host, c.cfg.MT5Port))
+// connect dials the server and authenticates. The connection is only put to
+// use once the authentication succeeded. ctx cancels the connection setup.
+func (c *MT5Client) connect(ctx context.Context) error {
+	c.setState(StateConnecting)
+	conn, err := c.dial(ctx)
 	if err != nil {
 		return err
 	}
 
-	c.conn, err = net.DialTCP("tcp", nil, mt5Addr)
+	c.setState(StateAuthenticating)
+	stop := interruptOnDone(ctx, conn)
+	err = c.auth(ctx, conn)
+	if stop() {
+		err = ctx.Err()
+	}
 	if err != nil {
+		_ = conn.Close()
 		return err
 	}
 
-	_, err = c.conn.Write([]byte("MT5WEBAPI"))
-	if err != nil {
-		return fmt.Errorf("error init MT5WEBAPI %v", err)
+	c.stateMux.Lock()
+	defer c.stateMux.Unlock()
+	if c.state == StateClosed {
+		_ = conn.Close()
+		return ErrClosed
 	}
 
+	c.connMux.Lock()
+	c.conn = conn
+	c.connMux.Unlock()
+	c.setStateLocked(StateConnected)
+
 	return nil
 }
 
+func (c *MT5Client) dial(ctx context.Context) (net.Conn, error) {
+	addr := net.JoinHostPort(c.cfg.MT5Host, strconv.Itoa(c.cfg.MT5Port))
+	c.log.Infof("Connecting to MT5 server %s", addr)
+
+	conn, err := c.dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = conn.Write([]byte("MT5WEBAPI"))
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("error init MT5WEBAPI %v", err)
+	}
+
+	return conn, nil
+}
+
+// interruptOnDone unblocks reads and writes on conn when ctx is done. The
+// returned function stops watching ctx and reports whether conn was
+// interrupted.
+func interruptOnDone(ctx context.Context, conn net.Conn) func() bool {
+	if ctx.Done() == nil {
+		return func() bool { return false }
+	}
+
+	stop := make(chan struct{})
+	interrupted := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.SetDeadline(time.Unix(1, 0))
+			interrupted <- true
+		case <-stop:
+			interrupted <- false
+		}
+	}()
+
+	return func() bool {
+		close(stop)
+		return <-interrupted
+	}
+}
+
 func (c *MT5Client) ping() {
-	var err error
 	for {
-		time.Sleep(time.Duration(c.cfg.MT5PingTimeout) * time.Second)
-		request, _ := makePacket("", 0, 0)
-		c.connMux.Lock()
-		if c.conn != nil {
-			_, err = c.conn.Write(request)
+		select {
+		case <-c.done:
+			return
+		case <-c.clock.After(time.Duration(c.cfg.MT5PingTimeout) * time.Second):
 		}
-		c.connMux.Unlock()
+
+		conn, err := c.activeConn()
 		if err != nil {
+			continue
+		}
+
+		request, _ := makePacket("", 0, 0)
+		if err = c.write(conn, request); err != nil {
 			c.log.Errorf("#%d ping request failed %v", c.clientId, err)
-			_ = c.reconnect()
+			c.startReconnect()
 		}
 	}
 }
 
-func safeSend(ch chan *ClientResponse, value *ClientResponse) (success bool) {
-	defer func() {
-		if recover() != nil {
-			success = false
-		}
-	}()
-	ch <- value
-	return true
+// respond sends the response to the message being handled, recording its
+// error on the call span. A transport error is retried on another connection
+// if the pool allows it, or reported as an *OutcomeUnknownError for a write
+// that may have reached the server. The response of an abandoned message is
+// dropped.
+func (c *MT5Client) respond(m *ClientControlMessage, resp *ClientResponse) {
+	m.err = resp.Err
+	if resp.Err != nil && m.span != nil {
+		m.span.RecordError(resp.Err)
+	}
+	if m.isAbandoned() {
+		c.log.Debugf("#%d %s abandoned, response dropped", c.clientId, m.Cmd.Name)
+		return
+	}
+	if resp.Err != nil && m.retry != nil && m.retry(m, c.clientId, resp.Err) {
+		return
+	}
+	resp.Err = outcomeUnknown(m.Cmd.Name, resp.Err)
+	if !m.deliver(resp, c.clock, time.Duration(c.cfg.MT5RequestTimeout)*time.Second) && !m.isAbandoned() {
+		c.log.Errorf("#%d %s response dropped, nobody received it or the outbox is full", c.clientId, m.Cmd.Name)
+	}
 }
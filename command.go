@@ -0,0 +1,267 @@
+package mt5client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrUnknownCommand is returned for a command that is not registered, use
+// Pool.Do to send it.
+var ErrUnknownCommand = errors.New("mt5 command is not registered")
+
+// RetcodeError is returned when the server answered a command with a retcode
+// other than MT5RetCodeSuccess.
+type RetcodeError struct {
+	Command string
+	Retcode string
+}
+
+func (e *RetcodeError) Error() string {
+	return fmt.Sprintf("%s retcode error: %s", e.Command, e.Retcode)
+}
+
+// Invoker sends a command over the connection and returns the response.
+type Invoker func(ctx context.Context, cmd *MT5Command) (*MT5Command, error)
+
+// Interceptor wraps every exchange of a command with the server, follow-up
+// commands such as DEALER_UPDATES included. It calls next to send the
+// command and may inspect or change the command and the response, or return
+// without calling next. A rejected command is returned along with its
+// *RetcodeError. The response of a streamed command has no payload.
+type Interceptor func(ctx context.Context, cmd *MT5Command, next Invoker) (*MT5Command, error)
+
+// WithInterceptor appends interceptors to the chain of the connections. They
+// run in order, before the built-in logging, auditing and metrics of the
+// commands.
+func WithInterceptor(interceptors ...Interceptor) Option {
+	return func(o *options) {
+		o.interceptors = append(o.interceptors, interceptors...)
+	}
+}
+
+// Decoder turns the response of a command into the value of
+// ClientResponse.Response.
+type Decoder func(x *Exchange) (interface{}, error)
+
+// CommandSpec declares how the connections execute a command.
+type CommandSpec struct {
+	// Class is used by the balancer, the rate limits, the circuit breakers
+	// and the retries. The zero value is CommandClassRead, which is retried
+	// whenever the connection breaks: set it for commands with side effects.
+	Class CommandClass
+	// Decode turns the response into ClientResponse.Response, which is
+	// left nil without it.
+	Decode Decoder
+	// Item creates the value an element of the response array is decoded
+	// to when the command is streamed, see ClientControlMessage.Stream.
+	// Commands without it cannot be streamed.
+	Item func() interface{}
+	// Span, if set, traces the exchanges of the command, follow-ups
+	// included, as children of a span of that name.
+	Span string
+}
+
+// Exchange is a command executed by a connection, passed to its decoder.
+type Exchange struct {
+	Request  *MT5Command
+	Response *MT5Command
+
+	ctx  context.Context
+	span Span
+	send Invoker
+}
+
+// Send executes a follow-up command on the same connection through the
+// interceptors.
+func (x *Exchange) Send(cmd *MT5Command) (*MT5Command, error) {
+	return x.send(x.ctx, cmd)
+}
+
+// Unmarshal decodes the JSON payload of the response into v.
+func (x *Exchange) Unmarshal(v interface{}) error {
+	return unmarshalPayload(x.Request.Name, x.Response.Payload, v)
+}
+
+// SetAttribute sets an attribute of the span of the command.
+func (x *Exchange) SetAttribute(key string, value interface{}) {
+	x.span.SetAttribute(key, value)
+}
+
+var (
+	commandsMux sync.RWMutex
+	commands    = map[string]CommandSpec{
+		MT5CommandOrderGetTotal:        {Class: CommandClassRead, Decode: decodeOrdersTotal},
+		MT5CommandOrderGetPage:         {Class: CommandClassRead, Decode: decodeOrders, Item: newOrder},
+		MT5CommandOrderGetBatch:        {Class: CommandClassRead, Decode: decodeOrders, Item: newOrder},
+		MT5CommandOrderGetHistoryTotal: {Class: CommandClassRead, Decode: decodeOrdersTotal},
+		MT5CommandOrderGetHistoryPage:  {Class: CommandClassRead, Decode: decodeOrders, Item: newOrder},
+		MT5CommandOrderGetHistoryBatch: {Class: CommandClassRead, Decode: decodeOrders, Item: newOrder},
+		MT5CommandDealGetTotal:         {Class: CommandClassRead, Decode: decodeDealsTotal},
+		MT5CommandDealGetPage:          {Class: CommandClassRead, Decode: decodeDeals, Item: newDeal},
+		MT5CommandDealGetBatch:         {Class: CommandClassRead, Decode: decodeDeals, Item: newDeal},
+		MT5CommandDealDelete:           {Class: CommandClassTradeWrite, Decode: decodeResponse},
+		MT5CommandDealAdd:              {Class: CommandClassTradeWrite, Decode: decodeDeal},
+		MT5CommandPositionGetTotal:     {Class: CommandClassRead, Decode: decodePositionsTotal},
+		MT5CommandPositionGetPage:      {Class: CommandClassRead, Decode: decodePositions, Item: newPosition},
+		MT5CommandPositionGetBatch:     {Class: CommandClassRead, Decode: decodePositions, Item: newPosition},
+		MT5CommandPositionDelete:       {Class: CommandClassTradeWrite, Decode: decodeResponse},
+		MT5CommandClientGetIds:         {Class: CommandClassRead, Decode: decodeClients},
+		MT5CommandUserGet:              {Class: CommandClassRead, Decode: decodeUser},
+		MT5CommandUserGetBatch:         {Class: CommandClassRead, Decode: decodeUsers},
+		MT5CommandUserAdd:              {Class: CommandClassUserWrite, Decode: decodePayload},
+		MT5CommandUserUpdate:           {Class: CommandClassUserWrite, Decode: decodePayload},
+		MT5CommandUserDelete:           {Class: CommandClassUserWrite},
+		MT5CommandUserAccountGetBatch:  {Class: CommandClassRead, Decode: decodeUserAccounts},
+		MT5CommandTradeBalance:         {Class: CommandClassTradeWrite, Decode: decodeTicket},
+		MT5CommandTradeCalcMargin:      {Class: CommandClassRead, Decode: decodeResponse},
+		MT5CommandTickGetHistory:       {Class: CommandClassRead, Decode: decodePayload},
+		MT5CommandChartGet:             {Class: CommandClassRead, Decode: decodePayload},
+		MT5CommandDealerSend:           {Class: CommandClassTradeWrite, Decode: decodeDealerSend, Span: "mt5client.dealer"},
+		MT5CommandMailSend:             {Class: CommandClassOther},
+		MT5CommandNewsSend:             {Class: CommandClassOther},
+		MT5CommandCommonGet:            {Class: CommandClassRead, Decode: decodeCommon},
+		MT5CommandTimeGet:              {Class: CommandClassRead, Decode: decodeTimeConfig},
+		MT5CommandTimeServer:           {Class: CommandClassRead, Decode: decodeServerTime},
+	}
+)
+
+// RegisterCommand declares how the connections execute a command, replacing
+// the declaration of a command of the same name. It is meant to be called
+// before the pools are created.
+func RegisterCommand(name string, spec CommandSpec) {
+	commandsMux.Lock()
+	defer commandsMux.Unlock()
+	commands[name] = spec
+}
+
+func lookupCommand(name string) (CommandSpec, bool) {
+	commandsMux.RLock()
+	defer commandsMux.RUnlock()
+	spec, ok := commands[name]
+	return spec, ok
+}
+
+// decodeResponse passes the response command itself.
+func decodeResponse(x *Exchange) (interface{}, error) {
+	return x.Response, nil
+}
+
+// decodePayload passes the raw payload of the response.
+func decodePayload(x *Exchange) (interface{}, error) {
+	return x.Response.Payload, nil
+}
+
+// newInvoker chains the interceptors of the options, the logging, the
+// auditing, the retcode check, in dry-run mode the simulation and the metrics
+// in front of the exchange over the wire. The retries are not part of the
+// chain, they send the message again on another connection, see
+// RetryPolicy.
+func (c *MT5Client) newInvoker(o *options) Invoker {
+	interceptors := make([]Interceptor, 0, len(o.interceptors)+5)
+	interceptors = append(interceptors, o.interceptors...)
+	interceptors = append(interceptors, c.logExchange)
+	if o.auditor != nil {
+		interceptors = append(interceptors, c.auditExchange)
+	}
+	interceptors = append(interceptors, checkRetcode)
+	if o.dryRun {
+		interceptors = append(interceptors, c.simulate)
+	}
+	interceptors = append(interceptors, c.measure)
+
+	invoke := c.exchange
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], invoke
+		invoke = func(ctx context.Context, cmd *MT5Command) (*MT5Command, error) {
+			return interceptor(ctx, cmd, next)
+		}
+	}
+	return invoke
+}
+
+// execute runs the message through the interceptors and decodes the response
+// as declared by the spec of its command.
+func (c *MT5Client) execute(m *ClientControlMessage, spec CommandSpec) {
+	ctx := m.context()
+	var span Span = nopSpan{}
+	if m.span != nil {
+		span = m.span
+	}
+	if spec.Span != "" {
+		ctx, span = c.tracer.Start(ctx, spec.Span)
+		defer span.End()
+	}
+
+	if m.Stream != nil {
+		c.streamItems(ctx, m, spec)
+		return
+	}
+
+	// The errors are recorded on the call span by respond.
+	own := spec.Span != ""
+	resp, err := c.invoke(ctx, m.Cmd)
+	simulated := Simulated(resp)
+	if simulated {
+		span.SetAttribute(AttrSimulated, true)
+	}
+	if err != nil {
+		if own {
+			span.RecordError(err)
+		}
+		c.respond(m, &ClientResponse{Cmd: m.Cmd, Err: err, Simulated: simulated})
+		return
+	}
+
+	var value interface{}
+	if spec.Decode != nil {
+		x := &Exchange{Request: m.Cmd, Response: resp, ctx: ctx, span: span, send: c.invoke}
+		if value, err = spec.Decode(x); err != nil && own {
+			span.RecordError(err)
+		}
+	}
+
+	c.respond(m, &ClientResponse{
+		Cmd:       m.Cmd,
+		Response:  value,
+		Err:       err,
+		ClientId:  c.clientId,
+		Simulated: simulated,
+	})
+}
+
+// exchange sends the command over the wire, streaming the response payload
+// if ctx carries a stream.
+func (c *MT5Client) exchange(ctx context.Context, cmd *MT5Command) (*MT5Command, error) {
+	req, _, err := c.makeRequest(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	if s, ok := ctx.Value(streamKey{}).(*stream); ok {
+		resp, payload, err := c.sendStreamRequest(ctx, req)
+		s.payload = payload
+		return resp, err
+	}
+	return c.sendRequest(ctx, req)
+}
+
+// logExchange logs the command and the response, redacted.
+func (c *MT5Client) logExchange(ctx context.Context, cmd *MT5Command, next Invoker) (*MT5Command, error) {
+	c.log.Debugf("#%d %s request: %s", c.clientId, cmd.Name, c.redactor.body(encodeCommand(cmd)))
+
+	resp, err := next(ctx, cmd)
+	if resp != nil {
+		c.log.Debugf("#%d %s response: %+v", c.clientId, resp.Name, c.redactor.params(resp.Name, resp.Params))
+	}
+	return resp, err
+}
+
+func checkRetcode(ctx context.Context, cmd *MT5Command, next Invoker) (*MT5Command, error) {
+	resp, err := next(ctx, cmd)
+	if err == nil && resp.Params[MT5RetCode] != MT5RetCodeSuccess {
+		err = &RetcodeError{Command: cmd.Name, Retcode: resp.Params[MT5RetCode]}
+	}
+	return resp, err
+}
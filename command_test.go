@@ -0,0 +1,91 @@
+package mt5client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestInterceptors(t *testing.T) {
+	s := newFakeServer(t, func(cmd *MT5Command) string {
+		if cmd.Name == "REJECT_GET" {
+			return cmd.Name + "|RETCODE=3 Invalid parameters|\r\n"
+		}
+		return cmd.Name + "|RETCODE=0 Done|SOURCE=" + cmd.Params["SOURCE"] + "|\r\n{}"
+	})
+
+	var mux sync.Mutex
+	var calls []string
+	var rejected error
+	trace := func(name string) Interceptor {
+		return func(ctx context.Context, cmd *MT5Command, next Invoker) (*MT5Command, error) {
+			mux.Lock()
+			calls = append(calls, name+">"+cmd.Name)
+			mux.Unlock()
+			resp, err := next(ctx, cmd)
+			mux.Lock()
+			calls = append(calls, "<"+name)
+			if err != nil {
+				rejected = err
+			}
+			mux.Unlock()
+			return resp, err
+		}
+	}
+	tag := func(ctx context.Context, cmd *MT5Command, next Invoker) (*MT5Command, error) {
+		if cmd.Name == "CACHED_GET" {
+			return &MT5Command{Name: cmd.Name, Params: map[string]string{MT5RetCode: MT5RetCodeSuccess}, Payload: "{}"}, nil
+		}
+		params := map[string]string{"SOURCE": "interceptor"}
+		for k, v := range cmd.Params {
+			params[k] = v
+		}
+		return next(ctx, &MT5Command{Name: cmd.Name, Params: params, Payload: cmd.Payload})
+	}
+	pool, err := New(s.config(), WithInterceptor(trace("a"), trace("b")), WithInterceptor(tag))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	ctx := context.Background()
+
+	resp, err := pool.Do(ctx, "TAGGED_GET", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Params["SOURCE"] != "interceptor" {
+		t.Errorf("server received %q, want the command changed by the interceptor", resp.Params["SOURCE"])
+	}
+	mux.Lock()
+	want := []string{"a>TAGGED_GET", "b>TAGGED_GET", "<b", "<a"}
+	if len(calls) != len(want) {
+		t.Fatalf("interceptors called %v, want %v", calls, want)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Errorf("interceptors called %v, want %v", calls, want)
+			break
+		}
+	}
+	mux.Unlock()
+
+	// An interceptor answers without the server.
+	if _, err := pool.Do(ctx, "CACHED_GET", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.count("CACHED_GET"); n != 0 {
+		t.Errorf("%d CACHED_GET sent, want the interceptor to answer", n)
+	}
+
+	// The interceptors see the retcode error of a rejected command.
+	if _, err := pool.Do(ctx, "REJECT_GET", nil, nil); err == nil {
+		t.Fatal("REJECT_GET succeeded")
+	}
+	mux.Lock()
+	defer mux.Unlock()
+	var retcodeErr *RetcodeError
+	if !errors.As(rejected, &retcodeErr) || retcodeErr.Retcode != "3 Invalid parameters" {
+		t.Errorf("interceptor received %v, want the *RetcodeError", rejected)
+	}
+}
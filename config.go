@@ -1,5 +1,9 @@
 package mt5client
 
+import (
+	"fmt"
+)
+
 type Config struct {
 	MT5Host           string
 	MT5Port           int
@@ -9,4 +13,67 @@ type Config struct {
 	MT5APIAgent       string
 	MT5PingTimeout    int
 	MT5RequestTimeout int
+	// MT5ReconnectMaxDelay caps the exponential backoff between reconnect
+	// attempts, in seconds. 30 seconds if not set.
+	MT5ReconnectMaxDelay int
+	// MT5ReconnectMaxAttempts stops reconnecting after this many failed
+	// attempts in a row, 0 means no limit.
+	MT5ReconnectMaxAttempts int
+	// MT5ReconnectTimeout stops reconnecting when the connection could not
+	// be restored within this many seconds, 0 means no limit.
+	MT5ReconnectTimeout int
+	// MT5PoolSize is the maximum number of connections, 1 if not set.
+	MT5PoolSize int
+	// MT5PoolMinSize connections are dialed when the pool starts and kept
+	// open. All MT5PoolSize connections are kept if not set.
+	MT5PoolMinSize int
+	// MT5PoolIdleTimeout closes connections above the minimum that were not
+	// used for this many seconds, 0 keeps them open.
+	MT5PoolIdleTimeout int
+}
+
+// ConfigError describes an invalid Config field.
+type ConfigError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("mt5client: invalid config %s: %s", e.Field, e.Reason)
+}
+
+// Validate checks that the config can be used to connect, it returns a
+// *ConfigError for the first invalid field.
+func (c *Config) Validate() error {
+	switch {
+	case c.MT5Host == "":
+		return &ConfigError{"MT5Host", "must not be empty"}
+	case c.MT5Port <= 0 || c.MT5Port > 65535:
+		return &ConfigError{"MT5Port", fmt.Sprintf("%d is not a valid port", c.MT5Port)}
+	case c.MT5Login == "":
+		return &ConfigError{"MT5Login", "must not be empty"}
+	case c.MT5Password == "":
+		return &ConfigError{"MT5Password", "must not be empty"}
+	case c.MT5APIVersion == "":
+		return &ConfigError{"MT5APIVersion", "must not be empty"}
+	case c.MT5PingTimeout <= 0:
+		return &ConfigError{"MT5PingTimeout", fmt.Sprintf("%d must be positive", c.MT5PingTimeout)}
+	case c.MT5RequestTimeout <= 0:
+		return &ConfigError{"MT5RequestTimeout", fmt.Sprintf("%d must be positive", c.MT5RequestTimeout)}
+	case c.MT5ReconnectMaxDelay < 0:
+		return &ConfigError{"MT5ReconnectMaxDelay", fmt.Sprintf("%d must not be negative", c.MT5ReconnectMaxDelay)}
+	case c.MT5ReconnectMaxAttempts < 0:
+		return &ConfigError{"MT5ReconnectMaxAttempts", fmt.Sprintf("%d must not be negative", c.MT5ReconnectMaxAttempts)}
+	case c.MT5ReconnectTimeout < 0:
+		return &ConfigError{"MT5ReconnectTimeout", fmt.Sprintf("%d must not be negative", c.MT5ReconnectTimeout)}
+	case c.MT5PoolSize < 0:
+		return &ConfigError{"MT5PoolSize", fmt.Sprintf("%d must not be negative", c.MT5PoolSize)}
+	case c.MT5PoolMinSize < 0:
+		return &ConfigError{"MT5PoolMinSize", fmt.Sprintf("%d must not be negative", c.MT5PoolMinSize)}
+	case c.MT5PoolSize > 0 && c.MT5PoolMinSize > c.MT5PoolSize:
+		return &ConfigError{"MT5PoolMinSize", fmt.Sprintf("%d exceeds MT5PoolSize %d", c.MT5PoolMinSize, c.MT5PoolSize)}
+	case c.MT5PoolIdleTimeout < 0:
+		return &ConfigError{"MT5PoolIdleTimeout", fmt.Sprintf("%d must not be negative", c.MT5PoolIdleTimeout)}
+	}
+	return nil
 }
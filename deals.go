@@ -1,19 +1,14 @@
 package mt5client
 
 import (
-	"encoding/json"
-	"errors"
+	"context"
 	"fmt"
 	"strconv"
 	"strings"
-	"time"
 )
 
 func (p *Pool) GetDealsTotal(login string, from, to int64) (int, error) {
-	cb := make(chan *ClientResponse)
-	defer close(cb)
-
-	p.getClient().controlCh <- &ClientControlMessage{
+	resp, err := p.call(&ClientControlMessage{
 		Cmd: &MT5Command{
 			Name: MT5CommandDealGetTotal,
 			Params: map[string]string{
@@ -22,24 +17,18 @@ func (p *Pool) GetDealsTotal(login string, from, to int64) (int, error) {
 				"TO":    fmt.Sprintf("%d", to),
 			},
 		},
-		Cb: cb,
+	}, p.requestTimeout())
+	if err != nil {
+		return 0, err
 	}
-
-	select {
-	case resp := <-cb:
-		if resp.Err != nil {
-			return 0, resp.Err
-		}
-		return resp.Response.(*DealsTotalResponse).Total, resp.Err
-	case <-time.After(time.Duration(p.cfg.MT5RequestTimeout) * time.Second):
-		break
+	if resp.Err != nil {
+		return 0, resp.Err
 	}
-
-	return 0, errors.New("timeout expired")
+	return resp.Response.(*DealsTotalResponse).Total, nil
 }
 
 func (p *Pool) GetDealsPage(login string, from, to int64, offset, total int) {
-	p.getClient().controlCh <- &ClientControlMessage{
+	p.dispatch(&ClientControlMessage{
 		Cmd: &MT5Command{
 			Name: MT5CommandDealGetPage,
 			Params: map[string]string{
@@ -51,11 +40,11 @@ func (p *Pool) GetDealsPage(login string, from, to int64, offset, total int) {
 			},
 		},
 		Cb: p.cb,
-	}
+	})
 }
 
 func (p *Pool) GetDealsBatch(login string, group, ticket []string, from, to int64) {
-	p.getClient().controlCh <- &ClientControlMessage{
+	p.dispatch(&ClientControlMessage{
 		Cmd: &MT5Command{
 			Name: MT5CommandDealGetBatch,
 			Params: map[string]string{
@@ -67,131 +56,72 @@ func (p *Pool) GetDealsBatch(login string, group, ticket []string, from, to int6
 			},
 		},
 		Cb: p.cb,
-	}
+	})
 }
 
-func (p *Pool) DeleteDeals(deals []uint64) error {
-	cb := make(chan *ClientResponse)
-	defer close(cb)
-
-	tickets := make([]string, 0, len(deals))
-	for _, d := range deals {
-		tickets = append(tickets, fmt.Sprintf("%d", d))
-	}
-
-	p.getClient().controlCh <- &ClientControlMessage{
+// StreamDealsBatch works like GetDealsBatch but decodes the response while it
+// is being received and calls fn for every deal, so the whole batch is never
+// held in memory. It returns when the batch is done, fn returns an error, ctx
+// is done or MT5RequestTimeout expires. Once it returned, at most the call of
+// fn in progress completes.
+func (p *Pool) StreamDealsBatch(ctx context.Context, login string, group, ticket []string, from, to int64, fn func(d *Deal) error) error {
+	resp, err := p.callContext(ctx, &ClientControlMessage{
 		Cmd: &MT5Command{
-			Name: MT5CommandDealDelete,
+			Name: MT5CommandDealGetBatch,
 			Params: map[string]string{
-				"TICKET": strings.Join(tickets, ","),
+				"LOGIN":  login,
+				"GROUP":  strings.Join(group, ","),
+				"TICKET": strings.Join(ticket, ","),
+				"FROM":   fmt.Sprintf("%d", from),
+				"TO":     fmt.Sprintf("%d", to),
 			},
 		},
-		Cb: cb,
-	}
-
-	select {
-	case resp := <-cb:
-		return resp.Err
-	case <-time.After(time.Duration(p.cfg.MT5RequestTimeout) * time.Second):
-		break
-	}
-
-	return errors.New("timeout expired")
-}
-
-func (c *MT5Client) getDealsTotal(m *ClientControlMessage) {
-	req, body, err := c.makeRequest(m.Cmd)
-	if err != nil {
-		safeSend(m.Cb, &ClientResponse{Cmd: m.Cmd, Err: err})
-		return
-	}
-
-	c.log.Debugf("#%d %s header: %s body: %s", c.clientId, m.Cmd.Name, req[:MT5HeaderLength], body)
-
-	cmd, err := c.sendRequest(req)
+		Stream: func(item interface{}) error {
+			return fn(item.(*Deal))
+		},
+	}, p.requestTimeout())
 	if err != nil {
-		safeSend(m.Cb, &ClientResponse{Cmd: m.Cmd, Err: err})
-		return
-	}
-
-	if cmd.Params[MT5RetCode] != MT5RetCodeSuccess {
-		safeSend(m.Cb, &ClientResponse{Cmd: m.Cmd, Err: fmt.Errorf("%s retcode error: %s (%s)", m.Cmd.Name, cmd.Params[MT5RetCode], req)})
-		return
+		return err
 	}
-
-	c.log.Debugf("%s response: %+v", cmd.Name, cmd.Params)
-
-	total, err := strconv.Atoi(cmd.Params["TOTAL"])
-	safeSend(m.Cb, &ClientResponse{
-		Cmd:      m.Cmd,
-		Response: &DealsTotalResponse{Total: total},
-		Err:      err,
-	})
+	return resp.Err
 }
 
-func (c *MT5Client) getDeals(m *ClientControlMessage) {
-	req, body, err := c.makeRequest(m.Cmd)
-	if err != nil {
-		m.Cb <- &ClientResponse{Cmd: m.Cmd, Err: err}
-		return
-	}
-
-	c.log.Debugf("#%d %s header: %s body: %s", c.clientId, m.Cmd.Name, req[:MT5HeaderLength], body)
-
-	cmd, err := c.sendRequest(req)
+// DeleteDeals is BulkDeleteDeals without the report. A delete above
+// DeletePolicy.ConfirmAbove is confirmed by the context of the pool, see
+// Pool.WithContext. Deals missing from the snapshot are reported with an
+// error wrapping ErrNotFound, as the server answers when there is none.
+func (p *Pool) DeleteDeals(deals []uint64) error {
+	report, err := p.BulkDeleteDeals(p.context(), deals)
 	if err != nil {
-		m.Cb <- &ClientResponse{Cmd: m.Cmd, Err: err}
-		return
-	}
-
-	if cmd.Params[MT5RetCode] != MT5RetCodeSuccess {
-		m.Cb <- &ClientResponse{Cmd: m.Cmd, Err: fmt.Errorf("%s retcode error: %s (%s)", m.Cmd.Name, cmd.Params[MT5RetCode], req)}
-		return
+		return err
 	}
+	return report.notFound()
+}
 
-	c.log.Debugf("#%d %s response: %+v", c.clientId, cmd.Name, cmd.Params)
+func decodeDealsTotal(x *Exchange) (interface{}, error) {
+	total, err := strconv.Atoi(x.Response.Params["TOTAL"])
+	return &DealsTotalResponse{Total: total}, err
+}
 
+func decodeDeals(x *Exchange) (interface{}, error) {
 	deals := make([]Deal, 0, 100)
-	err = json.Unmarshal([]byte(cmd.Payload), &deals)
-
-	if len(deals) > 0 {
-		c.log.Debugf("#%d Deals received: %d", c.clientId, len(deals))
-	}
-
-	m.Cb <- &ClientResponse{
-		Cmd: m.Cmd,
-		Response: &DealsResponse{
-			Deals: deals,
-		},
-		Err: err,
+	if err := x.Unmarshal(&deals); err != nil {
+		return nil, err
 	}
+	return &DealsResponse{Deals: deals}, nil
 }
 
-func (c *MT5Client) deleteDeals(m *ClientControlMessage) {
-	req, body, err := c.makeRequest(m.Cmd)
-	if err != nil {
-		safeSend(m.Cb, &ClientResponse{Cmd: m.Cmd, Err: err})
-		return
-	}
-
-	c.log.Debugf("#%d %s header: %s body: %s", c.clientId, m.Cmd.Name, req[:MT5HeaderLength], body)
-
-	cmd, err := c.sendRequest(req)
-	if err != nil {
-		safeSend(m.Cb, &ClientResponse{Cmd: m.Cmd, Err: err})
-		return
+func decodeDeal(x *Exchange) (interface{}, error) {
+	if x.Response.Payload == "" {
+		return nil, nil
 	}
-
-	if cmd.Params[MT5RetCode] != MT5RetCodeSuccess {
-		safeSend(m.Cb, &ClientResponse{Cmd: m.Cmd, Err: fmt.Errorf("%s retcode error: %s (%s)", m.Cmd.Name, cmd.Params[MT5RetCode], req)})
-		return
+	deal := &Deal{}
+	if err := x.Unmarshal(deal); err != nil {
+		return nil, err
 	}
+	return deal, nil
+}
 
-	c.log.Debugf("%s response: %+v", cmd.Name, cmd.Params)
-
-	safeSend(m.Cb, &ClientResponse{
-		Cmd:      m.Cmd,
-		Response: cmd,
-		Err:      err,
-	})
+func newDeal() interface{} {
+	return &Deal{}
 }
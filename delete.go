@@ -0,0 +1,476 @@
+package mt5client
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrTooManyRecords is returned for a delete of more records than
+	// DeletePolicy.MaxRecords, nothing is deleted.
+	ErrTooManyRecords = errors.New("mt5 delete exceeds the record limit")
+	// ErrConfirmationRequired matches the *ConfirmationRequiredError of
+	// every delete.
+	ErrConfirmationRequired = errors.New("mt5 delete requires confirmation")
+	// ErrNotFound is the error of the records missing from the snapshot
+	// taken before deleting, they are not sent.
+	ErrNotFound = errors.New("mt5 record not found")
+)
+
+// DefaultConfirmTTL is how long a confirmation token is valid unless
+// DeletePolicy.ConfirmTTL is set.
+const DefaultConfirmTTL = 5 * time.Minute
+
+// ConfirmationRequiredError is returned for a delete of more records than
+// DeletePolicy.ConfirmAbove, nothing is deleted. Repeat the call with a
+// context carrying Token, see WithConfirmation: the token only confirms the
+// same command of the same records on the same pool, until it expires. The
+// token is not part of the error message, so that it does not end up in the
+// logs.
+type ConfirmationRequiredError struct {
+	Command string
+	Records int
+	Token   string
+	Expires time.Time
+}
+
+func (e *ConfirmationRequiredError) Error() string {
+	return fmt.Sprintf("%s of %d records requires confirmation", e.Command, e.Records)
+}
+
+func (e *ConfirmationRequiredError) Is(target error) bool {
+	return target == ErrConfirmationRequired
+}
+
+// DeletePolicy limits the deletes of deals, positions and users. The zero
+// policy, the default, sets no limit and sends all the records at once.
+type DeletePolicy struct {
+	// MaxRecords is the most records a call may delete, 0 means no limit.
+	MaxRecords int
+	// ConfirmAbove is the number of records above which a call must be
+	// confirmed, 0 never requires it.
+	ConfirmAbove int
+	// ChunkSize is the number of tickets sent per command, 0 sends them
+	// all at once. The users are always deleted one by one.
+	ChunkSize int
+	// ConfirmTTL is how long a confirmation token is valid,
+	// DefaultConfirmTTL if 0.
+	ConfirmTTL time.Duration
+}
+
+// WithDeletePolicy sets the limits of the deletes of the pool, e.g.
+//
+//	mt5client.WithDeletePolicy(mt5client.DeletePolicy{
+//		MaxRecords:   10000,
+//		ConfirmAbove: 100,
+//		ChunkSize:    100,
+//	})
+func WithDeletePolicy(d DeletePolicy) Option {
+	return func(o *options) {
+		o.delete = d
+	}
+}
+
+type confirmationKey struct{}
+
+// WithConfirmation returns a copy of ctx confirming the delete the token of a
+// *ConfirmationRequiredError was issued for.
+func WithConfirmation(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, confirmationKey{}, token)
+}
+
+func confirmationFrom(ctx context.Context) string {
+	token, _ := ctx.Value(confirmationKey{}).(string)
+	return token
+}
+
+// newConfirmKey returns the random key of the confirmation tokens of a pool.
+func newConfirmKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("mt5 confirmation key: %w", err)
+	}
+	return key, nil
+}
+
+// confirmationToken is "<expiry>.<mac>": the unix time the token expires at
+// and the HMAC-SHA256 of the expiry, the command and the sorted ids with the
+// key of the pool.
+func confirmationToken(key []byte, expires time.Time, command string, ids []string) string {
+	sorted := append([]string(nil), ids...)
+	sort.Strings(sorted)
+
+	expiry := strconv.FormatInt(expires.Unix(), 10)
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(expiry))
+	h.Write([]byte{0})
+	h.Write([]byte(command))
+	for _, id := range sorted {
+		h.Write([]byte{0})
+		h.Write([]byte(id))
+	}
+	return expiry + "." + hex.EncodeToString(h.Sum(nil))
+}
+
+// confirmed reports whether token confirms the command of the ids and has
+// not expired.
+func (p *Pool) confirmed(token, command string, ids []string) bool {
+	i := strings.IndexByte(token, '.')
+	if i < 0 {
+		return false
+	}
+	expiry, err := strconv.ParseInt(token[:i], 10, 64)
+	if err != nil || !p.clock.Now().Before(time.Unix(expiry, 0)) {
+		return false
+	}
+	want := confirmationToken(p.confirmKey, time.Unix(expiry, 0), command, ids)
+	return hmac.Equal([]byte(token), []byte(want))
+}
+
+type DeleteStatus int
+
+const (
+	DeleteStatusDeleted DeleteStatus = iota
+	DeleteStatusNotFound
+	DeleteStatusFailed
+	// DeleteStatusSkipped records were not sent because a previous chunk
+	// failed.
+	DeleteStatusSkipped
+)
+
+func (s DeleteStatus) String() string {
+	switch s {
+	case DeleteStatusDeleted:
+		return "deleted"
+	case DeleteStatusNotFound:
+		return "not found"
+	case DeleteStatusFailed:
+		return "failed"
+	case DeleteStatusSkipped:
+		return "skipped"
+	}
+	return fmt.Sprintf("DeleteStatus(%d)", int(s))
+}
+
+// DeleteResult is the outcome of the delete of a record.
+type DeleteResult struct {
+	// ID is the ticket of the deal or position, or the login of the user.
+	ID     string
+	Status DeleteStatus
+	Err    error
+}
+
+// DeleteReport describes a delete record by record. The snapshot taken before
+// deleting, if any, is in Deals, Positions or Users.
+type DeleteReport struct {
+	Command   string
+	Results   []DeleteResult
+	Deals     []Deal
+	Positions []Position
+	Users     []*User
+	// Simulated is set if the deletes were not sent, see WithDryRun.
+	Simulated bool
+	// RecycleID is the id of the deleted records in the recycle bin, see
+	// WithRecycleBin and Pool.Restore.
+	RecycleID string
+
+	// rawDeals are the JSON objects of Deals as read from the server.
+	rawDeals []json.RawMessage
+	// last is the response of the last delete command.
+	last *ClientResponse
+}
+
+// notFound returns an error wrapping ErrNotFound if records were missing
+// from the snapshot.
+func (r *DeleteReport) notFound() error {
+	if n := r.Count(DeleteStatusNotFound); n > 0 {
+		return fmt.Errorf("%s of %d records, %d missing: %w", r.Command, len(r.Results), n, ErrNotFound)
+	}
+	return nil
+}
+
+// Count returns the number of records with the status.
+func (r *DeleteReport) Count(status DeleteStatus) int {
+	n := 0
+	for _, res := range r.Results {
+		if res.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// bulkDelete declares how the records of a delete are read and deleted.
+type bulkDelete struct {
+	command string
+	// batch is the GET_BATCH command of the snapshot, param the parameter
+	// listing the ids of both commands. raw passes the response of batch to
+	// snapshot as it is, see Pool.Do.
+	batch string
+	param string
+	raw   bool
+	// chunkSize overrides DeletePolicy.ChunkSize if set.
+	chunkSize int
+	timeout   time.Duration
+	// snapshot adds the records of the response to the report and returns
+	// their ids.
+	snapshot func(resp *ClientResponse, report *DeleteReport) ([]string, error)
+	// records returns the records of the snapshot with the ids for the
+	// recycle bin, nil if they are not kept.
+	records func(report *DeleteReport, ids map[string]bool) *DeletedRecords
+	// sendMissing also sends the ids missing from the snapshot, the server
+	// answers for them.
+	sendMissing bool
+}
+
+// BulkDeleteDeals deletes the deals within the limits of the DeletePolicy of
+// the pool. If the pool has a recycle bin or the policy a limit, the deals are
+// read with DEAL_GET_BATCH first and the ones not found are not sent,
+// otherwise all of them are sent and the server answers for the missing ones.
+// The deals are deleted in chunks until one fails. The error is that of the
+// failed chunk, the report tells the outcome of every deal.
+func (p *Pool) BulkDeleteDeals(ctx context.Context, deals []uint64) (*DeleteReport, error) {
+	return p.bulkDelete(ctx, &bulkDelete{
+		command: MT5CommandDealDelete,
+		batch:   MT5CommandDealGetBatch,
+		param:   "TICKET",
+		raw:     true,
+		snapshot: func(resp *ClientResponse, report *DeleteReport) ([]string, error) {
+			cmd := resp.Response.(*MT5Command)
+			var raw []json.RawMessage
+			if err := DecodeJSON(cmd, &raw); err != nil {
+				return nil, err
+			}
+			ids := make([]string, 0, len(raw))
+			for _, r := range raw {
+				var d Deal
+				if err := unmarshalPayload(cmd.Name, string(r), &d); err != nil {
+					return nil, err
+				}
+				report.Deals = append(report.Deals, d)
+				report.rawDeals = append(report.rawDeals, r)
+				ids = append(ids, fmt.Sprintf("%d", d.Deal))
+			}
+			return ids, nil
+		},
+		records: recycledDeals,
+	}, formatTickets(deals))
+}
+
+// BulkDeletePositions deletes the positions like BulkDeleteDeals. They are
+// never put in the recycle bin: the Web API cannot re-create a position, so
+// they cannot be restored.
+func (p *Pool) BulkDeletePositions(ctx context.Context, positions []uint64) (*DeleteReport, error) {
+	return p.bulkDelete(ctx, &bulkDelete{
+		command: MT5CommandPositionDelete,
+		batch:   MT5CommandPositionGetBatch,
+		param:   "TICKET",
+		snapshot: func(resp *ClientResponse, report *DeleteReport) ([]string, error) {
+			positions := resp.Response.(*PositionsResponse).Positions
+			report.Positions = append(report.Positions, positions...)
+			ids := make([]string, 0, len(positions))
+			for _, p := range positions {
+				ids = append(ids, fmt.Sprintf("%d", p.Position))
+			}
+			return ids, nil
+		},
+	}, formatTickets(positions))
+}
+
+// BulkDeleteUsers deletes the users one by one like BulkDeleteDeals.
+func (p *Pool) BulkDeleteUsers(ctx context.Context, logins []string) (*DeleteReport, error) {
+	return p.bulkDelete(ctx, usersDelete(p.requestTimeout()), logins)
+}
+
+func usersDelete(timeout time.Duration) *bulkDelete {
+	return &bulkDelete{
+		command:   MT5CommandUserDelete,
+		batch:     MT5CommandUserGetBatch,
+		param:     "LOGIN",
+		chunkSize: 1,
+		timeout:   timeout,
+		snapshot: func(resp *ClientResponse, report *DeleteReport) ([]string, error) {
+			users := resp.Response.([]*User)
+			report.Users = append(report.Users, users...)
+			ids := make([]string, 0, len(users))
+			for _, u := range users {
+				ids = append(ids, u.Login)
+			}
+			return ids, nil
+		},
+	}
+}
+
+// bulkDelete refuses more records than MaxRecords and, unless confirmed, more
+// than ConfirmAbove. It reads the records with the GET_BATCH command if the
+// recycle bin or the limits need them, then deletes the ones found in chunks
+// of ChunkSize, stopping at the first failed chunk. Nothing is deleted if the
+// snapshot fails or cannot be put in the recycle bin.
+func (p *Pool) bulkDelete(ctx context.Context, d *bulkDelete, ids []string) (*DeleteReport, error) {
+	ids = uniqueIds(ids)
+	report := &DeleteReport{Command: d.command}
+	if len(ids) == 0 {
+		return report, nil
+	}
+
+	policy := p.opts.delete
+	if policy.MaxRecords > 0 && len(ids) > policy.MaxRecords {
+		return nil, fmt.Errorf("%s of %d records, limit %d: %w", d.command, len(ids), policy.MaxRecords, ErrTooManyRecords)
+	}
+	if policy.ConfirmAbove > 0 && len(ids) > policy.ConfirmAbove && !p.confirmed(confirmationFrom(ctx), d.command, ids) {
+		ttl := policy.ConfirmTTL
+		if ttl <= 0 {
+			ttl = DefaultConfirmTTL
+		}
+		expires := p.clock.Now().Add(ttl)
+		return nil, &ConfirmationRequiredError{
+			Command: d.command,
+			Records: len(ids),
+			Token:   confirmationToken(p.confirmKey, expires, d.command, ids),
+			Expires: expires,
+		}
+	}
+	size := policy.ChunkSize
+	if d.chunkSize > 0 {
+		size = d.chunkSize
+	}
+	timeout := d.timeout
+	if timeout == 0 {
+		timeout = p.requestTimeout()
+	}
+
+	// Without a snapshot, every id is sent.
+	var batches [][]string
+	snapshot := p.opts.recycleBin != nil && d.records != nil || policy.MaxRecords > 0 || policy.ConfirmAbove > 0
+	if snapshot {
+		batches = chunkIds(ids, policy.ChunkSize)
+	}
+	sendMissing := d.sendMissing || !snapshot
+	found := make(map[string]bool, len(ids))
+	for _, chunk := range batches {
+		resp, err := p.callContext(ctx, &ClientControlMessage{
+			Cmd: &MT5Command{
+				Name:   d.batch,
+				Params: map[string]string{d.param: strings.Join(chunk, ",")},
+			},
+			raw: d.raw,
+		}, p.requestTimeout())
+		if err == nil {
+			err = resp.Err
+		}
+		if err != nil && !isNotFound(err) {
+			return nil, fmt.Errorf("%s snapshot: %w", d.command, err)
+		}
+		if err != nil {
+			continue
+		}
+		read, err := d.snapshot(resp, report)
+		if err != nil {
+			return nil, fmt.Errorf("%s snapshot: %w", d.command, err)
+		}
+		for _, id := range read {
+			found[id] = true
+		}
+	}
+
+	report.Results = make([]DeleteResult, len(ids))
+	index := make(map[string]int, len(ids))
+	targets := make([]string, 0, len(found))
+	for i, id := range ids {
+		index[id] = i
+		if found[id] || sendMissing {
+			report.Results[i] = DeleteResult{ID: id, Status: DeleteStatusSkipped}
+			targets = append(targets, id)
+		} else {
+			report.Results[i] = DeleteResult{ID: id, Status: DeleteStatusNotFound, Err: ErrNotFound}
+		}
+	}
+
+	recycled, err := p.recycle(ctx, d, report, found)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := chunkIds(targets, size)
+	p.log.Infof("MT5 %s of %d records in %d chunks, %d not found", d.command, len(targets), len(chunks), len(ids)-len(targets))
+	for _, chunk := range chunks {
+		var resp *ClientResponse
+		resp, err = p.callContext(ctx, &ClientControlMessage{
+			Cmd: &MT5Command{
+				Name:   d.command,
+				Params: map[string]string{d.param: strings.Join(chunk, ",")},
+			},
+		}, timeout)
+		if err == nil {
+			report.last = resp
+			report.Simulated = report.Simulated || resp.Simulated
+			err = resp.Err
+		}
+
+		status := DeleteStatusDeleted
+		if err != nil {
+			status = DeleteStatusFailed
+		}
+		for _, id := range chunk {
+			report.Results[index[id]] = DeleteResult{ID: id, Status: status, Err: err}
+		}
+		if err != nil {
+			p.log.Warnf("MT5 %s failed, %d records deleted, %d skipped: %v", d.command, report.Count(DeleteStatusDeleted), report.Count(DeleteStatusSkipped), err)
+			break
+		}
+	}
+	p.settleRecycled(d, report, recycled, found)
+	return report, err
+}
+
+// isNotFound reports whether the server answered that none of the records
+// exist.
+func isNotFound(err error) bool {
+	var retcodeErr *RetcodeError
+	return errors.As(err, &retcodeErr) && strings.HasPrefix(retcodeErr.Retcode, "13 ")
+}
+
+func formatTickets(tickets []uint64) []string {
+	ids := make([]string, 0, len(tickets))
+	for _, t := range tickets {
+		ids = append(ids, fmt.Sprintf("%d", t))
+	}
+	return ids
+}
+
+func uniqueIds(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	unique := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+func chunkIds(ids []string, size int) [][]string {
+	if size <= 0 {
+		size = len(ids)
+	}
+	var chunks [][]string
+	for len(ids) > size {
+		chunks = append(chunks, ids[:size])
+		ids = ids[size:]
+	}
+	if len(ids) > 0 {
+		chunks = append(chunks, ids)
+	}
+	return chunks
+}
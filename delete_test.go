@@ -0,0 +1,139 @@
+package mt5client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testClock is the system clock moved forward by Advance.
+type testClock struct {
+	mux    sync.Mutex
+	offset time.Duration
+}
+
+func (c *testClock) Now() time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return time.Now().Add(c.offset)
+}
+
+func (c *testClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.offset += d
+}
+
+// dealsServer answers DEAL_GET_BATCH with the deals of the tickets up to
+// 1000, the others do not exist.
+func dealsServer(t *testing.T) *fakeServer {
+	return newFakeServer(t, func(cmd *MT5Command) string {
+		if cmd.Name == MT5CommandDealDelete && cmd.Params["TICKET"] == "9999" {
+			return cmd.Name + "|RETCODE=13 Not found|\r\n"
+		}
+		if cmd.Name != MT5CommandDealGetBatch {
+			return cmd.Name + "|RETCODE=0 Done|\r\n"
+		}
+		deals := make([]string, 0)
+		for _, ticket := range strings.Split(cmd.Params["TICKET"], ",") {
+			if len(ticket) < 4 {
+				deals = append(deals, fmt.Sprintf(`{"Deal":"%s"}`, ticket))
+			}
+		}
+		return "DEAL_GET_BATCH|RETCODE=0 Done|\r\n[" + strings.Join(deals, ",") + "]"
+	})
+}
+
+func TestDeleteDealsPolicy(t *testing.T) {
+	s := dealsServer(t)
+	tickets := make([]uint64, 150)
+	for i := range tickets {
+		tickets[i] = uint64(i + 1)
+	}
+
+	pool, err := New(s.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.DeleteDeals(tickets); err != nil {
+		t.Errorf("DeleteDeals with the default policy: %v", err)
+	}
+	// Without a snapshot, the server answers for the missing deals.
+	var retcodeErr *RetcodeError
+	if err := pool.DeleteDeals([]uint64{9999}); !errors.As(err, &retcodeErr) || retcodeErr.Retcode != "13 Not found" {
+		t.Errorf("DeleteDeals of a missing deal returned %v, want the retcode of the server", err)
+	}
+	if n := s.count(MT5CommandDealGetBatch); n != 0 {
+		t.Errorf("%d snapshots taken with the default policy", n)
+	}
+	pool.Close()
+
+	clock := &testClock{}
+	pool, err = New(s.config(), WithClock(clock), WithDeletePolicy(DeletePolicy{ConfirmAbove: 100, ChunkSize: 100}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	if err := pool.DeleteDeals([]uint64{9999}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("DeleteDeals of a deal missing from the snapshot returned %v, want ErrNotFound", err)
+	}
+	_, err = pool.BulkDeleteDeals(context.Background(), tickets)
+	var confirmErr *ConfirmationRequiredError
+	if !errors.As(err, &confirmErr) {
+		t.Fatalf("BulkDeleteDeals returned %v, want a *ConfirmationRequiredError", err)
+	}
+	if strings.Contains(err.Error(), confirmErr.Token) {
+		t.Errorf("error %q contains the token", err)
+	}
+	if _, err := pool.BulkDeleteDeals(WithConfirmation(context.Background(), confirmErr.Token), tickets[1:]); !errors.Is(err, ErrConfirmationRequired) {
+		t.Errorf("token confirmed other deals: %v", err)
+	}
+	clock.Advance(DefaultConfirmTTL)
+	if _, err := pool.BulkDeleteDeals(WithConfirmation(context.Background(), confirmErr.Token), tickets); !errors.Is(err, ErrConfirmationRequired) {
+		t.Errorf("expired token confirmed the delete: %v", err)
+	}
+	_, err = pool.BulkDeleteDeals(context.Background(), tickets)
+	if !errors.As(err, &confirmErr) {
+		t.Fatalf("BulkDeleteDeals returned %v, want a *ConfirmationRequiredError", err)
+	}
+	report, err := pool.BulkDeleteDeals(WithConfirmation(context.Background(), confirmErr.Token), tickets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := report.Count(DeleteStatusDeleted); n != len(tickets) {
+		t.Errorf("%d deals deleted, want %d", n, len(tickets))
+	}
+
+	// Two commands for the default policy, two chunks once confirmed.
+	if n := s.count(MT5CommandDealDelete); n != 4 {
+		t.Errorf("%d DEAL_DELETE sent, want 4", n)
+	}
+}
+
+func TestDeleteUserNotFound(t *testing.T) {
+	s := newFakeServer(t, func(cmd *MT5Command) string {
+		return cmd.Name + "|RETCODE=13 Not found|\r\n"
+	})
+	pool, err := New(s.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	resp, err := pool.DeleteUser("1000", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var retcodeErr *RetcodeError
+	if !errors.As(resp.Err, &retcodeErr) || retcodeErr.Retcode != "13 Not found" {
+		t.Errorf("DeleteUser response error %v, want the retcode of the server", resp.Err)
+	}
+}
@@ -0,0 +1,309 @@
+package mt5client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// SimulatedParam is set to "1" in the responses made up in dry-run mode, see
+// WithDryRun and Simulated.
+const SimulatedParam = "SIMULATED"
+
+// Retcodes of the simulated rejections, as the server would answer them.
+const (
+	simulatedInvalidParams = "3 Invalid parameters"
+	simulatedNotFound      = "13 Not found"
+	simulatedNoMoney       = "10019 No money"
+	simulatedDealerDone    = "10009"
+	simulatedIdPrefix      = "simulated-"
+)
+
+var simulatedIds uint64
+
+// ErrNotSimulated is returned in dry-run mode for a command that is not
+// registered, so neither known to be a read nor simulated.
+var ErrNotSimulated = errors.New("mt5 command cannot be simulated in dry-run mode")
+
+// WithDryRun makes the pool simulate the writes: every registered command
+// other than the reads is validated and logged but never sent to the server.
+// Balance withdrawals are checked against the free margin of the account,
+// read from the server. Dealer requests opening a position are checked
+// against it too, with the margin required calculated by the server with
+// TRADE_CALC_MARGIN. Accepted commands get a successful response with no
+// ticket, deal or user created, marked by SimulatedParam and
+// ClientResponse.Simulated. Rejected ones fail with a *RetcodeError as the
+// server would: "3 Invalid parameters", "13 Not found", "10019 No money" or
+// the retcode of the margin calculation. Commands not registered, such as
+// those sent with Pool.Do, fail with ErrNotSimulated: register a read with
+// RegisterCommand to send it in dry-run mode.
+func WithDryRun(enabled bool) Option {
+	return func(o *options) {
+		o.dryRun = enabled
+	}
+}
+
+// DryRun reports whether the pool simulates the writes, see WithDryRun.
+func (p *Pool) DryRun() bool {
+	return p.opts.dryRun
+}
+
+// Simulated reports whether the response was made up in dry-run mode.
+func Simulated(cmd *MT5Command) bool {
+	return cmd != nil && cmd.Params[SimulatedParam] == "1"
+}
+
+// simulate answers the writes in place of the server, the reads and the
+// pre-checks are sent by next.
+func (c *MT5Client) simulate(ctx context.Context, cmd *MT5Command, next Invoker) (*MT5Command, error) {
+	if cmd.Name == MT5CommandDealerUpdates {
+		if id := cmd.Params["ID"]; strings.HasPrefix(id, simulatedIdPrefix) {
+			return simulatedDealerUpdates(id), nil
+		}
+		return next(ctx, cmd)
+	}
+	spec, ok := lookupCommand(cmd.Name)
+	if !ok {
+		c.log.Warnf("#%d dry run, %s not registered and not sent", c.clientId, cmd.Name)
+		return nil, fmt.Errorf("%s: %w", cmd.Name, ErrNotSimulated)
+	}
+	if spec.Class == CommandClassRead {
+		return next(ctx, cmd)
+	}
+
+	retcode, reason, err := c.checkWrite(ctx, cmd, next)
+	if err != nil {
+		return nil, err
+	}
+	if retcode != "" {
+		c.log.Warnf("#%d dry run, %s rejected: %s", c.clientId, cmd.Name, reason)
+		return simulatedResponse(cmd.Name, retcode), nil
+	}
+
+	c.log.Infof("#%d dry run, %s not sent: %s", c.clientId, cmd.Name, c.redactor.body(encodeCommand(cmd)))
+	resp := simulatedResponse(cmd.Name, MT5RetCodeSuccess)
+	switch cmd.Name {
+	case MT5CommandTradeBalance:
+		resp.Params["TICKET"] = "0"
+	case MT5CommandDealerSend:
+		id := fmt.Sprintf("%s%d", simulatedIdPrefix, atomic.AddUint64(&simulatedIds, 1))
+		resp.Payload = fmt.Sprintf(`{"id":%q}`, id)
+	case MT5CommandUserAdd:
+		login := cmd.Params["LOGIN"]
+		if login == "" {
+			login = "0"
+		}
+		user, _ := json.Marshal(map[string]string{
+			"Login":    login,
+			"Group":    cmd.Params["GROUP"],
+			"Name":     cmd.Params["NAME"],
+			"Rights":   cmd.Params["RIGHTS"],
+			"Leverage": cmd.Params["LEVERAGE"],
+		})
+		resp.Payload = string(user)
+	}
+	return resp, nil
+}
+
+// checkWrite validates the write and pre-checks the balance operations and
+// the dealer requests. It returns the retcode and the reason of a rejection,
+// an error if the account could not be read.
+func (c *MT5Client) checkWrite(ctx context.Context, cmd *MT5Command, next Invoker) (retcode, reason string, err error) {
+	invalid := func(format string, args ...interface{}) (string, string, error) {
+		return simulatedInvalidParams, fmt.Sprintf(format, args...), nil
+	}
+
+	switch cmd.Name {
+	case MT5CommandTradeBalance:
+		login := cmd.Params["LOGIN"]
+		if !isUint(login) {
+			return invalid("LOGIN %q is not a login", login)
+		}
+		if !isUint(cmd.Params["TYPE"]) {
+			return invalid("TYPE %q is not a deal action", cmd.Params["TYPE"])
+		}
+		amount, err := strconv.ParseFloat(cmd.Params["BALANCE"], 64)
+		if err != nil || amount == 0 {
+			return invalid("BALANCE %q is not a non-zero amount", cmd.Params["BALANCE"])
+		}
+		if amount > 0 {
+			return "", "", nil
+		}
+		account, err := c.account(ctx, login, next)
+		if err != nil {
+			return "", "", err
+		}
+		if account == nil {
+			return simulatedNotFound, fmt.Sprintf("account %s not found", login), nil
+		}
+		if -amount > float64(account.MarginFree) {
+			return simulatedNoMoney, fmt.Sprintf("withdrawal of %.2f exceeds the free margin %.2f of %s", -amount, account.MarginFree, login), nil
+		}
+
+	case MT5CommandDealerSend:
+		req := struct {
+			Login      string `json:"Login"`
+			Action     string `json:"Action"`
+			Type       string `json:"Type"`
+			Position   string `json:"Position"`
+			Symbol     string `json:"Symbol"`
+			Volume     string `json:"Volume"`
+			PriceOrder string `json:"PriceOrder"`
+		}{}
+		if err := json.Unmarshal([]byte(cmd.Payload), &req); err != nil {
+			return invalid("payload is not a trade request: %v", err)
+		}
+		if !isUint(req.Login) || !isUint(req.Action) {
+			return invalid("Login %q or Action %q missing", req.Login, req.Action)
+		}
+		account, err := c.account(ctx, req.Login, next)
+		if err != nil {
+			return "", "", err
+		}
+		if account == nil {
+			return simulatedNotFound, fmt.Sprintf("account %s not found", req.Login), nil
+		}
+		opens := req.Position == "" || req.Position == "0"
+		trade := req.Type == strconv.Itoa(DealActionBuy) || req.Type == strconv.Itoa(DealActionSell)
+		if !opens || !trade {
+			return "", "", nil
+		}
+		if req.Symbol == "" || !isUint(req.Volume) {
+			return invalid("Symbol %q or Volume %q missing", req.Symbol, req.Volume)
+		}
+		margin, retcode, err := c.calcMargin(ctx, req.Login, req.Symbol, req.Type, req.Volume, req.PriceOrder, next)
+		if err != nil {
+			return "", "", err
+		}
+		if retcode != MT5RetCodeSuccess {
+			return retcode, fmt.Sprintf("margin of %s %s on %s not calculated", req.Volume, req.Symbol, req.Login), nil
+		}
+		if margin > float64(account.MarginFree) {
+			return simulatedNoMoney, fmt.Sprintf("margin %.2f of %s %s exceeds the free margin %.2f of %s", margin, req.Volume, req.Symbol, account.MarginFree, req.Login), nil
+		}
+
+	case MT5CommandDealDelete, MT5CommandPositionDelete:
+		tickets := cmd.Params["TICKET"]
+		if tickets == "" {
+			return invalid("TICKET missing")
+		}
+		for _, t := range strings.Split(tickets, ",") {
+			if !isUint(t) {
+				return invalid("TICKET %q is not a ticket", t)
+			}
+		}
+
+	case MT5CommandUserAdd:
+		if cmd.Params["GROUP"] == "" || cmd.Params["PASS_MAIN"] == "" {
+			return invalid("GROUP or PASS_MAIN missing")
+		}
+
+	case MT5CommandUserUpdate, MT5CommandUserDelete:
+		if !isUint(cmd.Params["LOGIN"]) {
+			return invalid("LOGIN %q is not a login", cmd.Params["LOGIN"])
+		}
+	}
+	return "", "", nil
+}
+
+// account reads the account of the login for the pre-checks, nil if it does
+// not exist.
+func (c *MT5Client) account(ctx context.Context, login string, next Invoker) (*UserAccount, error) {
+	cmd := &MT5Command{
+		Name:   MT5CommandUserAccountGetBatch,
+		Params: map[string]string{"LOGIN": login},
+	}
+	resp, err := next(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Params[MT5RetCode] != MT5RetCodeSuccess {
+		return nil, nil
+	}
+
+	accounts := make([]*UserAccount, 0)
+	if err := unmarshalPayload(cmd.Name, resp.Payload, &accounts); err != nil {
+		return nil, err
+	}
+	for _, a := range accounts {
+		if a.Login == login {
+			return a, nil
+		}
+	}
+	return nil, nil
+}
+
+// calcMargin asks the server for the margin required by a trade of volume,
+// in the units of DEALER_SEND, at price or the current price if empty. It
+// returns the retcode of the calculation.
+func (c *MT5Client) calcMargin(ctx context.Context, login, symbol, orderType, volume, price string, next Invoker) (float64, string, error) {
+	cmd := &MT5Command{
+		Name: MT5CommandTradeCalcMargin,
+		Params: map[string]string{
+			"LOGIN":  login,
+			"SYMBOL": symbol,
+			"TYPE":   orderType,
+			"VOLUME": volume,
+			"PRICE":  price,
+		},
+	}
+	resp, err := next(ctx, cmd)
+	if err != nil {
+		return 0, "", err
+	}
+	retcode := resp.Params[MT5RetCode]
+	if retcode != MT5RetCodeSuccess {
+		return 0, retcode, nil
+	}
+
+	margin := resp.Params["MARGIN"]
+	if margin == "" {
+		answer := struct {
+			Margin json.Number `json:"Margin"`
+		}{}
+		if err := unmarshalPayload(cmd.Name, resp.Payload, &answer); err != nil {
+			return 0, "", err
+		}
+		margin = answer.Margin.String()
+	}
+	value, err := strconv.ParseFloat(margin, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("%s margin %q: %v", cmd.Name, margin, err)
+	}
+	return value, retcode, nil
+}
+
+func simulatedResponse(name, retcode string) *MT5Command {
+	return &MT5Command{
+		Name: name,
+		Params: map[string]string{
+			MT5RetCode:     retcode,
+			SimulatedParam: "1",
+		},
+	}
+}
+
+// simulatedDealerUpdates answers DEALER_UPDATES for a simulated DEALER_SEND
+// with a result of no deal.
+func simulatedDealerUpdates(id string) *MT5Command {
+	resp := simulatedResponse(MT5CommandDealerUpdates, MT5RetCodeSuccess)
+	updates, _ := json.Marshal(map[string][]map[string]map[string]string{
+		id: {{"result": {
+			"ID":      id,
+			"DealID":  "0",
+			"Retcode": simulatedDealerDone,
+			"Comment": "simulated",
+		}}},
+	})
+	resp.Payload = string(updates)
+	return resp
+}
+
+func isUint(s string) bool {
+	_, err := strconv.ParseUint(s, 10, 64)
+	return err == nil
+}
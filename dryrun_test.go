@@ -0,0 +1,57 @@
+package mt5client
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestDryRunDealerSendMargin(t *testing.T) {
+	s := newFakeServer(t, func(cmd *MT5Command) string {
+		switch cmd.Name {
+		case MT5CommandUserAccountGetBatch:
+			return "USER_ACCOUNT_GET_BATCH|RETCODE=0 Done|\r\n[{\"Login\":\"1000\",\"MarginFree\":\"500\"}]"
+		case MT5CommandTradeCalcMargin:
+			switch cmd.Params["SYMBOL"] {
+			case "EURUSD":
+				return "TRADE_CALC_MARGIN|RETCODE=0 Done|\r\n{\"Margin\":\"100.50\"}"
+			case "XAUUSD":
+				return "TRADE_CALC_MARGIN|RETCODE=0 Done|\r\n{\"Margin\":\"1200\"}"
+			}
+			return "TRADE_CALC_MARGIN|RETCODE=13 Not found|\r\n"
+		}
+		return cmd.Name + "|RETCODE=0 Done|\r\n"
+	})
+	pool, err := New(s.config(), WithDryRun(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	send := func(symbol string) error {
+		payload := `{"Action":"200","Login":"1000","Type":"0","Volume":"10000","Symbol":"` + symbol + `"}`
+		_, err := pool.Do(context.Background(), MT5CommandDealerSend, nil, []byte(payload))
+		return err
+	}
+	if err := send("EURUSD"); err != nil {
+		t.Errorf("EURUSD rejected: %v", err)
+	}
+	for symbol, retcode := range map[string]string{"XAUUSD": simulatedNoMoney, "UNKNOWN": "13 Not found"} {
+		var retcodeErr *RetcodeError
+		if err := send(symbol); !errors.As(err, &retcodeErr) || retcodeErr.Retcode != retcode {
+			t.Errorf("%s returned %v, want retcode %s", symbol, err, retcode)
+		}
+	}
+
+	if _, err := pool.Do(context.Background(), "GROUP_ADD", nil, []byte(`{}`)); !errors.Is(err, ErrNotSimulated) {
+		t.Errorf("unregistered command returned %v, want ErrNotSimulated", err)
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for _, name := range s.commands {
+		if name == MT5CommandDealerSend || name == "GROUP_ADD" {
+			t.Errorf("%s sent in dry-run mode", name)
+		}
+	}
+}
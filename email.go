@@ -0,0 +1,131 @@
+package mt5client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// EmailPolicy decides what AddUser and UpdateUser store in the EMAIL field of
+// an MT5 account.
+type EmailPolicy int
+
+const (
+	// EmailMask keeps the first and last character of the local part and of
+	// every domain label but the top-level one, e.g. "john@mail.example.com"
+	// becomes "j**n@m**l.e*****e.com".
+	EmailMask EmailPolicy = iota
+	// EmailRaw stores the address as is.
+	EmailRaw
+	// EmailHash stores the hex SHA-256 of the lower-cased address, which
+	// still allows matching accounts by e-mail.
+	EmailHash
+	// EmailOmit does not send the EMAIL field, UpdateUser then leaves the
+	// stored address unchanged.
+	EmailOmit
+)
+
+// ErrInvalidEmail is wrapped by the errors of addresses that cannot be
+// stored. The address itself is not included in the error.
+var ErrInvalidEmail = errors.New("invalid email")
+
+func (e EmailPolicy) String() string {
+	switch e {
+	case EmailMask:
+		return "mask"
+	case EmailRaw:
+		return "raw"
+	case EmailHash:
+		return "hash"
+	case EmailOmit:
+		return "omit"
+	}
+	return fmt.Sprintf("EmailPolicy(%d)", int(e))
+}
+
+// setEmail sets the EMAIL param according to the pool email policy. An empty
+// address is sent as is.
+func (p *Pool) setEmail(params map[string]string, email string) error {
+	if p.emailPolicy == EmailOmit {
+		return nil
+	}
+	if email == "" {
+		params["EMAIL"] = ""
+		return nil
+	}
+
+	local, labels, err := splitEmail(email)
+	if err != nil {
+		return err
+	}
+
+	switch p.emailPolicy {
+	case EmailRaw:
+		params["EMAIL"] = email
+	case EmailHash:
+		sum := sha256.Sum256([]byte(strings.ToLower(email)))
+		params["EMAIL"] = hex.EncodeToString(sum[:])
+	default:
+		masked := make([]string, len(labels))
+		for i, label := range labels {
+			if i == len(labels)-1 {
+				masked[i] = label
+			} else {
+				masked[i] = maskValue(label)
+			}
+		}
+		params["EMAIL"] = maskValue(local) + "@" + strings.Join(masked, ".")
+	}
+	return nil
+}
+
+// splitEmail validates an address and returns its local part and domain
+// labels. Internationalised local parts and domains are accepted, in Unicode
+// or punycode form.
+func splitEmail(email string) (string, []string, error) {
+	if !utf8.ValidString(email) {
+		return "", nil, fmt.Errorf("%w: not valid UTF-8", ErrInvalidEmail)
+	}
+	for _, r := range email {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return "", nil, fmt.Errorf("%w: contains spaces or control characters", ErrInvalidEmail)
+		}
+	}
+
+	at := strings.LastIndexByte(email, '@')
+	if at < 0 {
+		return "", nil, fmt.Errorf("%w: no @", ErrInvalidEmail)
+	}
+	local, domain := email[:at], email[at+1:]
+	if local == "" {
+		return "", nil, fmt.Errorf("%w: empty local part", ErrInvalidEmail)
+	}
+
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return "", nil, fmt.Errorf("%w: domain has no dot", ErrInvalidEmail)
+	}
+	for _, label := range labels {
+		if label == "" {
+			return "", nil, fmt.Errorf("%w: empty domain label", ErrInvalidEmail)
+		}
+	}
+
+	return local, labels, nil
+}
+
+// maskValue replaces all characters but the first and the last with '*', all
+// but the first for values of up to two characters.
+func maskValue(value string) string {
+	runes := []rune(value)
+	for i := range runes {
+		if i != 0 && (i != len(runes)-1 || len(runes) <= 2) {
+			runes[i] = '*'
+		}
+	}
+	return string(runes)
+}
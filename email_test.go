@@ -0,0 +1,58 @@
+package mt5client
+
+import (
+	"errors"
+	"testing"
+)
+
+type emailTest struct {
+	policy EmailPolicy
+	email  string
+	want   string
+	err    bool
+}
+
+func TestSetEmail(t *testing.T) {
+	const hash = "855f96e983f1f8e8be944692b6f719fd54329826cb62e98015efee8e2e071dd4"
+	tests := []emailTest{
+		{EmailMask, "john@mail.example.com", "j**n@m**l.e*****e.com", false},
+		{EmailMask, "jo@ab.cd", "j*@a*.cd", false},
+		{EmailMask, "дом@пример.рф", "д*м@п****р.рф", false},
+		{EmailMask, "", "", false},
+		{EmailRaw, "John@Example.com", "John@Example.com", false},
+		{EmailHash, "John@Example.com", hash, false},
+		{EmailHash, "", "", false},
+		{EmailOmit, "foo", "", false},
+		{EmailOmit, "a@", "", false},
+	}
+	for _, policy := range []EmailPolicy{EmailMask, EmailRaw, EmailHash} {
+		for _, email := range []string{"foo", "a@", "@example.com", "a@b", "a@b..com", "a@.com", "a b@c.com", "a\n@c.com", "\xff@c.com"} {
+			tests = append(tests, emailTest{policy, email, "", true})
+		}
+	}
+	for _, tt := range tests {
+		p := &Pool{emailPolicy: tt.policy}
+		params := make(map[string]string)
+		err := p.setEmail(params, tt.email)
+		if tt.err {
+			if !errors.Is(err, ErrInvalidEmail) {
+				t.Errorf("%s %q returned %v, want ErrInvalidEmail", tt.policy, tt.email, err)
+			}
+			if _, ok := params["EMAIL"]; ok {
+				t.Errorf("%s %q set EMAIL to %q", tt.policy, tt.email, params["EMAIL"])
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %q returned %v", tt.policy, tt.email, err)
+			continue
+		}
+		email, ok := params["EMAIL"]
+		if tt.policy == EmailOmit && ok {
+			t.Errorf("%s %q set EMAIL to %q", tt.policy, tt.email, email)
+		}
+		if tt.policy != EmailOmit && email != tt.want {
+			t.Errorf("%s %q set EMAIL to %q, want %q", tt.policy, tt.email, email, tt.want)
+		}
+	}
+}
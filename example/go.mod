@@ -0,0 +1,10 @@
+module github.com/IT-Kungfu/mt5client/example
+
+go 1.15
+
+require (
+	github.com/IT-Kungfu/logger v0.0.0-20210212114133-6f6c14aa57ae
+	github.com/IT-Kungfu/mt5client v0.0.0
+)
+
+replace github.com/IT-Kungfu/mt5client => ../
@@ -12,7 +12,7 @@ import (
 
 var (
 	log    *logger.Logger
-	mt5cfg *mt5client.Config
+	mt5cfg mt5client.Config
 	mt5    *mt5client.Pool
 )
 
@@ -29,7 +29,7 @@ func init() {
 		panic(err)
 	}
 
-	mt5cfg = &mt5client.Config{
+	mt5cfg = mt5client.Config{
 		MT5Host:           "mt5hostname",
 		MT5Port:           443,
 		MT5Login:          "login",
@@ -38,18 +38,16 @@ func init() {
 		MT5APIAgent:       "mt5client",
 		MT5PingTimeout:    20,
 		MT5RequestTimeout: 10,
+		MT5PoolSize:       10,
 	}
 }
 
 func main() {
-	services := map[string]interface{}{
-		"mt5cfg": mt5cfg,
-		"log":    log,
-	}
-	ctx := context.WithValue(context.Background(), "services", services)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
 
 	var err error
-	mt5, err = mt5client.NewMT5ClientPool(ctx, 10)
+	mt5, err = mt5client.Dial(ctx, mt5cfg, mt5client.WithLogger(mt5client.PrintfLogger(log)))
 	if err != nil {
 		log.Fatalf("MT5 client error: %v", err)
 	}
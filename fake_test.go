@@ -0,0 +1,151 @@
+package mt5client
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// fakeServer is a Web API server accepting any credentials. handler answers
+// the commands other than the authentication with a response body,
+// e.g. "COMMON_GET|RETCODE=0 Done|\r\n{}", or closes the connection with an
+// empty one. The responses are split in packets of up to chunk bytes.
+type fakeServer struct {
+	l       net.Listener
+	handler func(cmd *MT5Command) string
+	chunk   int
+
+	mux      sync.Mutex
+	commands []string
+	// logins counts the authentications, authRetcode answers them if set.
+	logins      int
+	authRetcode string
+}
+
+func newFakeServer(t *testing.T, handler func(cmd *MT5Command) string) *fakeServer {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{l: l, handler: handler, chunk: 0x7fff}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// rejectAuth answers the next authentications with retcode, accepts them
+// again if empty.
+func (s *fakeServer) rejectAuth(retcode string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.authRetcode = retcode
+}
+
+// count returns the number of commands named name received.
+func (s *fakeServer) count(name string) int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	n := 0
+	for _, c := range s.commands {
+		if c == name {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *fakeServer) loginCount() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.logins
+}
+
+// config returns the configuration of a single connection to the server.
+func (s *fakeServer) config() Config {
+	return Config{
+		MT5Host:           "127.0.0.1",
+		MT5Port:           s.l.Addr().(*net.TCPAddr).Port,
+		MT5Login:          "1000",
+		MT5Password:       "password",
+		MT5APIVersion:     "1",
+		MT5PingTimeout:    30,
+		MT5RequestTimeout: 2,
+		MT5PoolSize:       1,
+	}
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+	defer conn.Close()
+	// Skip the MT5WEBAPI prefix.
+	if _, err := io.ReadFull(conn, make([]byte, 9)); err != nil {
+		return
+	}
+	for {
+		header := make([]byte, 9)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		n, _ := strconv.ParseUint(string(header[:4]), 16, 16)
+		if n == 0 {
+			continue
+		}
+		body := make([]byte, n)
+		if _, err := io.ReadFull(conn, body); err != nil {
+			return
+		}
+		cmd, err := parseBody(body)
+		if err != nil {
+			return
+		}
+
+		var resp string
+		switch cmd.Name {
+		case MT5CommandAuthStart:
+			resp = "AUTH_START|RETCODE=0 Done|SRV_RAND=00112233445566778899aabbccddeeff|\r\n"
+		case MT5CommandAuthAnswer:
+			s.mux.Lock()
+			s.logins++
+			retcode := s.authRetcode
+			s.mux.Unlock()
+			resp = "AUTH_ANSWER|RETCODE=0 Done|CLI_RAND_ANSWER=00|\r\n"
+			if retcode != "" {
+				resp = "AUTH_ANSWER|RETCODE=" + retcode + "|\r\n"
+			}
+		case MT5CommandQuit:
+			return
+		default:
+			s.mux.Lock()
+			s.commands = append(s.commands, cmd.Name)
+			s.mux.Unlock()
+			resp = s.handler(cmd)
+		}
+		if resp == "" {
+			return
+		}
+		for len(resp) > 0 {
+			chunk, flag := resp, uint8(0)
+			if len(chunk) > s.chunk {
+				chunk, flag = chunk[:s.chunk], 1
+			}
+			resp = resp[len(chunk):]
+			packet, err := makePacket(chunk, 0, flag)
+			if err != nil {
+				return
+			}
+			if _, err := conn.Write(packet); err != nil {
+				return
+			}
+		}
+	}
+}
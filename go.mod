@@ -2,7 +2,4 @@ module github.com/IT-Kungfu/mt5client
 
 go 1.15
 
-require (
-	github.com/IT-Kungfu/logger v0.0.0-20210212114133-6f6c14aa57ae
-	golang.org/x/text v0.3.5
-)
+require golang.org/x/text v0.3.5
@@ -0,0 +1,172 @@
+package mt5client
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	eventsBufferSize     = 64
+	subscriberBufferSize = 16
+)
+
+// ConnStateEvent is emitted whenever a pool connection changes its state.
+type ConnStateEvent struct {
+	ClientId int
+	From     ConnState
+	To       ConnState
+	// Err is the error that caused a reconnect or a permanent failure.
+	Err  error
+	Time time.Time
+}
+
+// ConnHealth is a snapshot of a single pool connection.
+type ConnHealth struct {
+	ClientId      int
+	State         ConnState
+	LastSuccess   time.Time
+	LastError     error
+	LastErrorTime time.Time
+	Reconnects    int
+	InFlight      int
+}
+
+// PoolHealth is a snapshot of all pool connections.
+type PoolHealth struct {
+	Connections []ConnHealth
+	Connected   int
+}
+
+// Ready reports whether at least one connection can serve requests.
+func (h *PoolHealth) Ready() bool {
+	return h.Connected > 0
+}
+
+// Health returns the current state of every pool connection.
+func (p *Pool) Health() *PoolHealth {
+	p.clients.mux.RLock()
+	defer p.clients.mux.RUnlock()
+
+	h := &PoolHealth{
+		Connections: make([]ConnHealth, 0, len(p.clients.list)),
+	}
+	for _, c := range p.clients.list {
+		ch := c.handler.health()
+		if ch.State == StateConnected {
+			h.Connected++
+		}
+		h.Connections = append(h.Connections, ch)
+	}
+	return h
+}
+
+// Subscribe returns a channel receiving the state changes of all pool
+// connections and a function to cancel the subscription. Events are dropped
+// for a subscriber that does not keep up. The channel is closed when the
+// subscription is cancelled or the pool is closed.
+func (p *Pool) Subscribe() (<-chan *ConnStateEvent, func()) {
+	ch := make(chan *ConnStateEvent, subscriberBufferSize)
+	p.subscribers.add(ch)
+	return ch, func() {
+		p.subscribers.remove(ch)
+	}
+}
+
+func (p *Pool) broadcast() {
+	for {
+		select {
+		case e := <-p.events:
+			p.subscribers.send(e)
+		case <-p.done:
+			for {
+				select {
+				case e := <-p.events:
+					p.subscribers.send(e)
+				default:
+					p.subscribers.closeAll()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (c *MT5Client) health() ConnHealth {
+	c.stateMux.Lock()
+	defer c.stateMux.Unlock()
+	return ConnHealth{
+		ClientId:      c.clientId,
+		State:         c.state,
+		LastSuccess:   c.lastSuccess,
+		LastError:     c.lastErr,
+		LastErrorTime: c.lastErrTime,
+		Reconnects:    c.reconnects,
+		InFlight:      int(atomic.LoadInt64(&c.inFlight)),
+	}
+}
+
+// recordResult keeps the time of the last successful request or the last
+// transport error for the health snapshot.
+func (c *MT5Client) recordResult(err error) {
+	c.stateMux.Lock()
+	defer c.stateMux.Unlock()
+	if err != nil {
+		c.lastErr = err
+		c.lastErrTime = c.clock.Now()
+	} else {
+		c.lastSuccess = c.clock.Now()
+	}
+}
+
+type subscribers struct {
+	mux    sync.Mutex
+	chs    map[chan *ConnStateEvent]struct{}
+	closed bool
+}
+
+func newSubscribers() *subscribers {
+	return &subscribers{
+		chs: make(map[chan *ConnStateEvent]struct{}),
+	}
+}
+
+func (s *subscribers) add(ch chan *ConnStateEvent) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.closed {
+		close(ch)
+		return
+	}
+	s.chs[ch] = struct{}{}
+}
+
+func (s *subscribers) remove(ch chan *ConnStateEvent) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if _, ok := s.chs[ch]; ok {
+		delete(s.chs, ch)
+		close(ch)
+	}
+}
+
+func (s *subscribers) send(e *ConnStateEvent) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for ch := range s.chs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+func (s *subscribers) closeAll() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for ch := range s.chs {
+		delete(s.chs, ch)
+		close(ch)
+	}
+	s.closed = true
+}
@@ -0,0 +1,69 @@
+package mt5client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHealthAndSubscribe(t *testing.T) {
+	s := newFakeServer(t, func(cmd *MT5Command) string {
+		return cmd.Name + "|RETCODE=0 Done|\r\n{}"
+	})
+	cfg := s.config()
+	cfg.MT5PoolSize = 2
+	pool, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := pool.Health()
+	if !h.Ready() || h.Connected != 2 || len(h.Connections) != 2 {
+		t.Fatalf("health %+v, want 2 connections ready", h)
+	}
+	if _, err := pool.GetCommon(); err != nil {
+		t.Fatal(err)
+	}
+	succeeded := 0
+	for _, c := range pool.Health().Connections {
+		if c.State != StateConnected || c.InFlight != 0 || c.LastError != nil {
+			t.Errorf("connection %+v, want connected and idle", c)
+		}
+		if !c.LastSuccess.IsZero() {
+			succeeded++
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%d connections with a last success, want the one of COMMON_GET", succeeded)
+	}
+
+	events, _ := pool.Subscribe()
+	cancelled, cancel := pool.Subscribe()
+	cancel()
+	if _, ok := <-cancelled; ok {
+		t.Error("event received after cancelling the subscription")
+	}
+	cancel()
+
+	pool.Close()
+	closed := 0
+	timeout := time.After(5 * time.Second)
+	for open := true; open; {
+		select {
+		case e, ok := <-events:
+			if ok && e.To == StateClosed {
+				closed++
+			}
+			open = ok
+		case <-timeout:
+			t.Fatal("subscription not closed with the pool")
+		}
+	}
+	if closed != 2 {
+		t.Errorf("%d connections closed, want 2 events", closed)
+	}
+	if late, _ := pool.Subscribe(); late != nil {
+		if _, ok := <-late; ok {
+			t.Error("subscription of a closed pool not closed")
+		}
+	}
+}
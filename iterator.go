@@ -0,0 +1,357 @@
+package mt5client
+
+import (
+	"context"
+	"fmt"
+)
+
+const DefaultPageSize = 1000
+
+// Cursor is the position of an iterator in a paged result. Store it and pass
+// it to StartAt to resume an interrupted iteration.
+type Cursor struct {
+	Offset int
+}
+
+type page struct {
+	items []interface{}
+	err   error
+}
+
+// pageIterator fetches the total and then the pages of a paged command. Pages
+// are requested concurrently, up to one per pool connection, and yielded in
+// order.
+type pageIterator struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	pool     *Pool
+	pageSize int
+	total    func(ctx context.Context) (int, error)
+	fetch    func(ctx context.Context, offset, count int) ([]interface{}, error)
+
+	started bool
+	offset  int
+	pages   chan chan page
+	items   []interface{}
+	current interface{}
+	err     error
+}
+
+func newPageIterator(ctx context.Context, p *Pool, pageSize int) *pageIterator {
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return &pageIterator{
+		ctx:      ctx,
+		cancel:   cancel,
+		pool:     p,
+		pageSize: pageSize,
+	}
+}
+
+func (it *pageIterator) startAt(c Cursor) {
+	if !it.started {
+		it.offset = c.Offset
+	}
+}
+
+// Next advances to the next item. It returns false when the iteration is
+// done or failed, check Err to tell the two apart.
+func (it *pageIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+
+	if !it.started {
+		it.started = true
+		total, err := it.total(it.ctx)
+		if err != nil {
+			it.fail(err)
+			return false
+		}
+		it.pages = make(chan chan page, it.pool.poolSize)
+		go it.produce(total)
+	}
+
+	for len(it.items) == 0 {
+		var res chan page
+		var ok bool
+		select {
+		case res, ok = <-it.pages:
+		case <-it.ctx.Done():
+			it.fail(it.ctx.Err())
+			return false
+		}
+		if !ok {
+			it.current = nil
+			it.cancel()
+			return false
+		}
+
+		var pg page
+		select {
+		case pg = <-res:
+		case <-it.ctx.Done():
+			it.fail(it.ctx.Err())
+			return false
+		}
+		if pg.err != nil {
+			it.fail(pg.err)
+			return false
+		}
+		if len(pg.items) == 0 {
+			// The result shrank since the total was fetched.
+			it.current = nil
+			it.cancel()
+			return false
+		}
+		it.items = pg.items
+	}
+
+	it.current = it.items[0]
+	it.items = it.items[1:]
+	it.offset++
+	return true
+}
+
+func (it *pageIterator) produce(total int) {
+	defer close(it.pages)
+	for offset := it.offset; offset < total; offset += it.pageSize {
+		count := it.pageSize
+		if offset+count > total {
+			count = total - offset
+		}
+
+		res := make(chan page, 1)
+		select {
+		case it.pages <- res:
+		case <-it.ctx.Done():
+			return
+		}
+
+		go func(offset, count int) {
+			items, err := it.fetch(it.ctx, offset, count)
+			res <- page{items: items, err: err}
+		}(offset, count)
+	}
+}
+
+func (it *pageIterator) fail(err error) {
+	it.err = err
+	it.current = nil
+	it.cancel()
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *pageIterator) Err() error {
+	return it.err
+}
+
+// Cursor returns the position right after the last item returned by Next.
+func (it *pageIterator) Cursor() Cursor {
+	return Cursor{Offset: it.offset}
+}
+
+// Close stops fetching pages. It is only needed when the iteration is
+// abandoned before Next returns false.
+func (it *pageIterator) Close() {
+	it.cancel()
+}
+
+type DealsIterator struct {
+	*pageIterator
+}
+
+// IterateDeals iterates over the deals of login between from and to, fetching
+// pageSize deals per request.
+func (p *Pool) IterateDeals(ctx context.Context, login string, from, to int64, pageSize int) *DealsIterator {
+	it := newPageIterator(ctx, p, pageSize)
+	it.total = func(ctx context.Context) (int, error) {
+		resp, err := p.request(ctx, &MT5Command{
+			Name: MT5CommandDealGetTotal,
+			Params: map[string]string{
+				"LOGIN": login,
+				"FROM":  fmt.Sprintf("%d", from),
+				"TO":    fmt.Sprintf("%d", to),
+			},
+		})
+		if err != nil {
+			return 0, err
+		}
+		return resp.Response.(*DealsTotalResponse).Total, nil
+	}
+	it.fetch = func(ctx context.Context, offset, count int) ([]interface{}, error) {
+		resp, err := p.request(ctx, &MT5Command{
+			Name: MT5CommandDealGetPage,
+			Params: map[string]string{
+				"LOGIN":  login,
+				"FROM":   fmt.Sprintf("%d", from),
+				"TO":     fmt.Sprintf("%d", to),
+				"OFFSET": fmt.Sprintf("%d", offset),
+				"TOTAL":  fmt.Sprintf("%d", count),
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		deals := resp.Response.(*DealsResponse).Deals
+		items := make([]interface{}, 0, len(deals))
+		for i := range deals {
+			items = append(items, &deals[i])
+		}
+		return items, nil
+	}
+	return &DealsIterator{it}
+}
+
+// StartAt resumes the iteration at c. It must be called before Next.
+func (it *DealsIterator) StartAt(c Cursor) *DealsIterator {
+	it.startAt(c)
+	return it
+}
+
+// Deal returns the current deal.
+func (it *DealsIterator) Deal() *Deal {
+	d, _ := it.current.(*Deal)
+	return d
+}
+
+type OrdersIterator struct {
+	*pageIterator
+}
+
+// IterateOrders iterates over the open orders of login.
+func (p *Pool) IterateOrders(ctx context.Context, login string, pageSize int) *OrdersIterator {
+	it := newPageIterator(ctx, p, pageSize)
+	it.total = func(ctx context.Context) (int, error) {
+		resp, err := p.request(ctx, &MT5Command{
+			Name:   MT5CommandOrderGetTotal,
+			Params: map[string]string{"LOGIN": login},
+		})
+		if err != nil {
+			return 0, err
+		}
+		return resp.Response.(*OrdersTotalResponse).Total, nil
+	}
+	it.fetch = func(ctx context.Context, offset, count int) ([]interface{}, error) {
+		return p.fetchOrders(ctx, &MT5Command{
+			Name: MT5CommandOrderGetPage,
+			Params: map[string]string{
+				"LOGIN":  login,
+				"OFFSET": fmt.Sprintf("%d", offset),
+				"TOTAL":  fmt.Sprintf("%d", count),
+			},
+		})
+	}
+	return &OrdersIterator{it}
+}
+
+// IterateOrdersHistory iterates over the history orders of login between
+// from and to.
+func (p *Pool) IterateOrdersHistory(ctx context.Context, login string, from, to int64, pageSize int) *OrdersIterator {
+	it := newPageIterator(ctx, p, pageSize)
+	it.total = func(ctx context.Context) (int, error) {
+		resp, err := p.request(ctx, &MT5Command{
+			Name: MT5CommandOrderGetHistoryTotal,
+			Params: map[string]string{
+				"LOGIN": login,
+				"FROM":  fmt.Sprintf("%d", from),
+				"TO":    fmt.Sprintf("%d", to),
+			},
+		})
+		if err != nil {
+			return 0, err
+		}
+		return resp.Response.(*OrdersTotalResponse).Total, nil
+	}
+	it.fetch = func(ctx context.Context, offset, count int) ([]interface{}, error) {
+		return p.fetchOrders(ctx, &MT5Command{
+			Name: MT5CommandOrderGetHistoryPage,
+			Params: map[string]string{
+				"LOGIN":  login,
+				"FROM":   fmt.Sprintf("%d", from),
+				"TO":     fmt.Sprintf("%d", to),
+				"OFFSET": fmt.Sprintf("%d", offset),
+				"TOTAL":  fmt.Sprintf("%d", count),
+			},
+		})
+	}
+	return &OrdersIterator{it}
+}
+
+func (p *Pool) fetchOrders(ctx context.Context, cmd *MT5Command) ([]interface{}, error) {
+	resp, err := p.request(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	orders := resp.Response.(*OrdersResponse).Orders
+	items := make([]interface{}, 0, len(orders))
+	for i := range orders {
+		items = append(items, &orders[i])
+	}
+	return items, nil
+}
+
+// StartAt resumes the iteration at c. It must be called before Next.
+func (it *OrdersIterator) StartAt(c Cursor) *OrdersIterator {
+	it.startAt(c)
+	return it
+}
+
+// Order returns the current order.
+func (it *OrdersIterator) Order() *Order {
+	o, _ := it.current.(*Order)
+	return o
+}
+
+type PositionsIterator struct {
+	*pageIterator
+}
+
+// IteratePositions iterates over the open positions of login.
+func (p *Pool) IteratePositions(ctx context.Context, login string, pageSize int) *PositionsIterator {
+	it := newPageIterator(ctx, p, pageSize)
+	it.total = func(ctx context.Context) (int, error) {
+		resp, err := p.request(ctx, &MT5Command{
+			Name:   MT5CommandPositionGetTotal,
+			Params: map[string]string{"LOGIN": login},
+		})
+		if err != nil {
+			return 0, err
+		}
+		return resp.Response.(*PositionsTotalResponse).Total, nil
+	}
+	it.fetch = func(ctx context.Context, offset, count int) ([]interface{}, error) {
+		resp, err := p.request(ctx, &MT5Command{
+			Name: MT5CommandPositionGetPage,
+			Params: map[string]string{
+				"LOGIN":  login,
+				"OFFSET": fmt.Sprintf("%d", offset),
+				"TOTAL":  fmt.Sprintf("%d", count),
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		positions := resp.Response.(*PositionsResponse).Positions
+		items := make([]interface{}, 0, len(positions))
+		for i := range positions {
+			items = append(items, &positions[i])
+		}
+		return items, nil
+	}
+	return &PositionsIterator{it}
+}
+
+// StartAt resumes the iteration at c. It must be called before Next.
+func (it *PositionsIterator) StartAt(c Cursor) *PositionsIterator {
+	it.startAt(c)
+	return it
+}
+
+// Position returns the current position.
+func (it *PositionsIterator) Position() *Position {
+	p, _ := it.current.(*Position)
+	return p
+}
@@ -0,0 +1,74 @@
+package mt5client
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestIterateDeals(t *testing.T) {
+	const total = 25
+	s := newFakeServer(t, func(cmd *MT5Command) string {
+		switch cmd.Name {
+		case MT5CommandDealGetTotal:
+			return fmt.Sprintf("DEAL_GET_TOTAL|RETCODE=0 Done|TOTAL=%d|\r\n", total)
+		case MT5CommandDealGetPage:
+			offset, _ := strconv.Atoi(cmd.Params["OFFSET"])
+			count, _ := strconv.Atoi(cmd.Params["TOTAL"])
+			if cmd.Params["LOGIN"] == "broken" && offset >= 14 {
+				return "DEAL_GET_PAGE|RETCODE=3 Invalid parameters|\r\n"
+			}
+			deals := make([]string, 0, count)
+			for i := offset; i < offset+count && i < total; i++ {
+				deals = append(deals, fmt.Sprintf(`{"Deal":"%d"}`, i+1))
+			}
+			return "DEAL_GET_PAGE|RETCODE=0 Done|\r\n[" + strings.Join(deals, ",") + "]"
+		}
+		return cmd.Name + "|RETCODE=0 Done|\r\n"
+	})
+	cfg := s.config()
+	cfg.MT5PoolSize = 2
+	pool, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	collect := func(it *DealsIterator) []uint64 {
+		defer it.Close()
+		var tickets []uint64
+		for it.Next() {
+			tickets = append(tickets, it.Deal().Deal)
+		}
+		return tickets
+	}
+
+	it := pool.IterateDeals(context.Background(), "1000", 0, 1, 7)
+	tickets := collect(it)
+	if it.Err() != nil || len(tickets) != total {
+		t.Fatalf("%d deals iterated: %v", len(tickets), it.Err())
+	}
+	for i, ticket := range tickets {
+		if ticket != uint64(i+1) {
+			t.Fatalf("deal %d at position %d", ticket, i)
+		}
+	}
+
+	it = pool.IterateDeals(context.Background(), "1000", 0, 1, 7).StartAt(Cursor{Offset: 10})
+	if tickets := collect(it); len(tickets) != total-10 || tickets[0] != 11 {
+		t.Errorf("resumed iteration returned %v", tickets)
+	}
+
+	// A failed page stops the iteration, the cursor resumes it after the
+	// last deal returned.
+	it = pool.IterateDeals(context.Background(), "broken", 0, 1, 7)
+	tickets = collect(it)
+	if it.Err() == nil || len(tickets) != 14 {
+		t.Errorf("%d deals iterated before %v, want 14 and the error of the page", len(tickets), it.Err())
+	}
+	if c := it.Cursor(); c.Offset != 14 {
+		t.Errorf("cursor at %d, want 14", c.Offset)
+	}
+}
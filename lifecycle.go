@@ -0,0 +1,260 @@
+package mt5client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrAbandoned is recorded on the span of a message whose caller stopped
+// waiting before it was sent to the server.
+var ErrAbandoned = errors.New("mt5 request abandoned by the caller")
+
+// callState is shared by the attempts of a pool call.
+type callState struct {
+	once      sync.Once
+	abandoned chan struct{}
+}
+
+func newCallState() *callState {
+	return &callState{abandoned: make(chan struct{})}
+}
+
+// abandon tells the connections that the caller of the message stopped
+// waiting for its response.
+func (m *ClientControlMessage) abandon() {
+	if m.call != nil {
+		m.call.once.Do(func() {
+			close(m.call.abandoned)
+		})
+	}
+}
+
+// abandonedCh returns a channel closed once the message is abandoned, nil if
+// it never will be.
+func (m *ClientControlMessage) abandonedCh() <-chan struct{} {
+	if m.call == nil {
+		return nil
+	}
+	return m.call.abandoned
+}
+
+func (m *ClientControlMessage) isAbandoned() bool {
+	select {
+	case <-m.abandonedCh():
+		return true
+	default:
+		return false
+	}
+}
+
+// deliver sends the response to the callback channel. The response of an
+// asynchronous call is queued in its outbox, dropped only if the outbox is
+// full, see WithOutboxCapacity. For a call with
+// a waiting caller, it is dropped once the caller abandons the call or after
+// timeout if nobody receives it. deliver recovers from a channel closed by a
+// departed caller, so the connection never blocks or panics on it.
+func (m *ClientControlMessage) deliver(resp *ClientResponse, clock Clock, timeout time.Duration) (success bool) {
+	if m.call == nil && m.outbox != nil {
+		return m.outbox.push(m.Cb, resp)
+	}
+
+	defer func() {
+		if recover() != nil {
+			success = false
+		}
+	}()
+
+	if m.call == nil {
+		m.Cb <- resp
+		return true
+	}
+	select {
+	case m.Cb <- resp:
+		return true
+	case <-m.abandonedCh():
+		return false
+	case <-clock.After(timeout):
+		return false
+	}
+}
+
+// DefaultOutboxCapacity is the number of responses of asynchronous calls
+// queued for their callback channels, see WithOutboxCapacity.
+const DefaultOutboxCapacity = 1024
+
+// WithOutboxCapacity sets how many responses of asynchronous calls, e.g.
+// GetDealsPage, are queued until their callback channel is read,
+// DefaultOutboxCapacity by default. Once the queue is full, the new responses
+// are dropped, logged and counted in PoolStats.OutboxDropped.
+func WithOutboxCapacity(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.outboxCapacity = n
+		}
+	}
+}
+
+// outbox hands the responses of the asynchronous calls over to their
+// callback channels in order, however slowly they are read, up to capacity
+// responses. pending counts the responses taken by forward and not sent yet.
+type outbox struct {
+	mux      sync.Mutex
+	queue    []outboxEntry
+	pending  int
+	capacity int
+	dropped  uint64
+	ready    chan struct{}
+}
+
+type outboxEntry struct {
+	cb   chan *ClientResponse
+	resp *ClientResponse
+}
+
+func newOutbox(capacity int) *outbox {
+	return &outbox{capacity: capacity, ready: make(chan struct{}, 1)}
+}
+
+// push queues the response, it returns false and drops it if the outbox is
+// full.
+func (o *outbox) push(cb chan *ClientResponse, resp *ClientResponse) bool {
+	o.mux.Lock()
+	if len(o.queue)+o.pending >= o.capacity {
+		o.dropped++
+		o.mux.Unlock()
+		return false
+	}
+	o.queue = append(o.queue, outboxEntry{cb: cb, resp: resp})
+	o.mux.Unlock()
+
+	select {
+	case o.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// stats returns the number of responses queued and dropped so far.
+func (o *outbox) stats() (depth int, dropped uint64) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	return len(o.queue) + o.pending, o.dropped
+}
+
+// forward sends the queued responses until done is closed.
+func (o *outbox) forward(done <-chan struct{}) {
+	for {
+		o.mux.Lock()
+		queue := o.queue
+		o.queue = nil
+		o.pending = len(queue)
+		o.mux.Unlock()
+
+		for _, e := range queue {
+			select {
+			case e.cb <- e.resp:
+			case <-done:
+				return
+			}
+			o.mux.Lock()
+			o.pending--
+			o.mux.Unlock()
+		}
+
+		select {
+		case <-o.ready:
+		case <-done:
+			return
+		}
+	}
+}
+
+func (p *Pool) requestTimeout() time.Duration {
+	return time.Duration(p.cfg.MT5RequestTimeout) * time.Second
+}
+
+// call dispatches the message and waits for its response until timeout
+// expires, see callContext.
+func (p *Pool) call(m *ClientControlMessage, timeout time.Duration) (*ClientResponse, error) {
+	return p.callContext(p.context(), m, timeout)
+}
+
+// callContext dispatches the message and waits for its response until
+// timeout expires or ctx is done. The callback channel is buffered and never
+// closed. A message the caller stopped waiting for is abandoned: it is not
+// sent if it has not reached a connection yet and its late response is
+// dropped.
+func (p *Pool) callContext(ctx context.Context, m *ClientControlMessage, timeout time.Duration) (*ClientResponse, error) {
+	cb := make(chan *ClientResponse, 1)
+	m.Cb = cb
+	m.call = newCallState()
+
+	expired := p.clock.After(timeout)
+	go p.send(ctx, m)
+
+	select {
+	case resp := <-cb:
+		return resp, nil
+	case <-ctx.Done():
+		m.abandon()
+		return nil, ctx.Err()
+	case <-expired:
+		m.abandon()
+		return nil, ErrTimeout
+	}
+}
+
+// watchAbandoned recycles the connection if the message is abandoned while
+// being handled and its response has not been drained within
+// MT5RequestTimeout: the connection cannot be reused while the response may
+// still arrive. The returned function stops watching.
+func (c *MT5Client) watchAbandoned(m *ClientControlMessage) func() {
+	abandoned := m.abandonedCh()
+	if abandoned == nil {
+		return func() {}
+	}
+
+	var mux sync.Mutex
+	stopped := false
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-stop:
+			return
+		case <-abandoned:
+		}
+
+		timeout := time.Duration(c.cfg.MT5RequestTimeout) * time.Second
+		select {
+		case <-stop:
+		case <-c.clock.After(timeout):
+			mux.Lock()
+			defer mux.Unlock()
+			if stopped {
+				return
+			}
+			c.log.Warnf("#%d %s abandoned and not answered within %s, recycling the connection", c.clientId, m.Cmd.Name, timeout)
+			c.interrupt()
+		}
+	}()
+
+	return func() {
+		mux.Lock()
+		stopped = true
+		mux.Unlock()
+		close(stop)
+	}
+}
+
+// interrupt unblocks the reads and writes on the current connection, the
+// request in progress fails and the connection is reconnected.
+func (c *MT5Client) interrupt() {
+	c.connMux.Lock()
+	conn := c.conn
+	c.connMux.Unlock()
+	if conn != nil {
+		_ = conn.SetDeadline(time.Unix(1, 0))
+	}
+}
@@ -0,0 +1,95 @@
+package mt5client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOutboxCapacity(t *testing.T) {
+	s := newFakeServer(t, func(cmd *MT5Command) string {
+		return cmd.Name + "|RETCODE=0 Done|\r\n[]"
+	})
+	pool, err := New(s.config(), WithOutboxCapacity(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	// Nobody reads Response: one response fits in its buffer, two in the
+	// outbox, the others are dropped.
+	for i := 0; i < 5; i++ {
+		pool.GetDealsPage("1000", 0, 1, i, 1)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for pool.stats().OutboxDropped < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("stats %+v, want 2 responses dropped", pool.stats())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats := pool.stats(); stats.OutboxDepth != 2 || stats.OutboxDropped != 2 {
+		t.Errorf("stats %+v, want 2 responses queued and 2 dropped", stats)
+	}
+
+	for i := 0; i < 3; i++ {
+		select {
+		case resp := <-pool.Response():
+			if resp.Err != nil {
+				t.Errorf("response %d: %v", i, resp.Err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("response %d not delivered", i)
+		}
+	}
+	select {
+	case resp := <-pool.Response():
+		t.Errorf("dropped response delivered: %+v", resp)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if depth := pool.stats().OutboxDepth; depth != 0 {
+		t.Errorf("%d responses left in the outbox", depth)
+	}
+}
+
+func TestAbandonedCall(t *testing.T) {
+	release := make(chan struct{})
+	s := newFakeServer(t, func(cmd *MT5Command) string {
+		if cmd.Name == "SLOW_GET" {
+			<-release
+		}
+		return cmd.Name + "|RETCODE=0 Done|\r\n{}"
+	})
+	pool, err := New(s.config(), WithRetryPolicy(RetryPolicy{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := pool.Do(ctx, "NEVER_GET", nil, nil); err == nil {
+		t.Error("call with a cancelled context succeeded")
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := pool.Do(ctx, "SLOW_GET", nil, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("slow call returned %v, want context.DeadlineExceeded", err)
+	}
+	close(release)
+
+	// The late response of the abandoned call is drained, not taken for the
+	// response of the next one.
+	resp, err := pool.Do(context.Background(), "FAST_GET", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Name != "FAST_GET" {
+		t.Errorf("FAST_GET answered by %s", resp.Name)
+	}
+	if n := s.count("NEVER_GET"); n != 0 {
+		t.Errorf("abandoned NEVER_GET sent %d times", n)
+	}
+}
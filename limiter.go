@@ -0,0 +1,224 @@
+package mt5client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned when a command would have to wait for the rate
+// limiter beyond its deadline.
+var ErrRateLimited = errors.New("mt5 rate limit exceeded")
+
+// Limit restrains the commands sent to the server, see WithRateLimit and
+// WithClassLimit. The zero value does not limit anything.
+type Limit struct {
+	// Rate is the number of commands per second, 0 means no rate limit.
+	Rate float64
+	// Burst is the number of commands that may be sent at once, 1 if not
+	// set.
+	Burst int
+	// MaxConcurrent is the number of commands in flight, 0 means no limit.
+	MaxConcurrent int
+}
+
+// WithRateLimit limits all commands of the pool.
+func WithRateLimit(l Limit) Option {
+	return func(o *options) {
+		o.limit = l
+	}
+}
+
+// WithClassLimit limits the commands of a class, in addition to the limit
+// of all commands.
+func WithClassLimit(class CommandClass, l Limit) Option {
+	return func(o *options) {
+		if o.classLimits == nil {
+			o.classLimits = make(map[CommandClass]Limit)
+		}
+		o.classLimits[class] = l
+	}
+}
+
+// limiter applies the global limit and the one of the command class. Commands
+// wait in turn for a token and a concurrency slot until their deadline.
+type limiter struct {
+	clock   Clock
+	metrics Metrics
+	global  *limit
+	classes map[CommandClass]*limit
+}
+
+type limit struct {
+	bucket *tokenBucket
+	slots  chan struct{}
+}
+
+func newLimiter(o *options) *limiter {
+	l := &limiter{
+		clock:   o.clock,
+		metrics: o.metrics,
+		global:  newLimit(o.limit, o.clock),
+		classes: make(map[CommandClass]*limit),
+	}
+	for class, cl := range o.classLimits {
+		if lim := newLimit(cl, o.clock); lim != nil {
+			l.classes[class] = lim
+		}
+	}
+	if l.global == nil && len(l.classes) == 0 {
+		return nil
+	}
+	return l
+}
+
+func newLimit(l Limit, clock Clock) *limit {
+	if l.Rate <= 0 && l.MaxConcurrent <= 0 {
+		return nil
+	}
+	lim := &limit{}
+	if l.Rate > 0 {
+		burst := l.Burst
+		if burst < 1 {
+			burst = 1
+		}
+		lim.bucket = &tokenBucket{
+			clock:  clock,
+			rate:   l.Rate,
+			burst:  float64(burst),
+			tokens: float64(burst),
+			last:   clock.Now(),
+		}
+	}
+	if l.MaxConcurrent > 0 {
+		lim.slots = make(chan struct{}, l.MaxConcurrent)
+	}
+	return lim
+}
+
+// wait blocks until the command may be sent, ctx is done or deadline is
+// reached. The returned function releases the concurrency slots once the
+// command has been handled.
+func (l *limiter) wait(ctx context.Context, class CommandClass, deadline time.Time) (func(), error) {
+	start := l.clock.Now()
+	release, err := l.acquire(ctx, class, deadline)
+	l.metrics.ObserveLimiterWait(class, l.clock.Now().Sub(start), err)
+	return release, err
+}
+
+func (l *limiter) acquire(ctx context.Context, class CommandClass, deadline time.Time) (func(), error) {
+	limits := make([]*limit, 0, 2)
+	if l.global != nil {
+		limits = append(limits, l.global)
+	}
+	if lim, ok := l.classes[class]; ok {
+		limits = append(limits, lim)
+	}
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	// Reserve the tokens of all limits first so a rejected command does not
+	// consume any of them.
+	var delay time.Duration
+	reserved := make([]*tokenBucket, 0, len(limits))
+	cancel := func() {
+		for _, b := range reserved {
+			b.cancel()
+		}
+	}
+	for _, lim := range limits {
+		if lim.bucket == nil {
+			continue
+		}
+		d, ok := lim.bucket.reserve(deadline)
+		if !ok {
+			cancel()
+			return nil, ErrRateLimited
+		}
+		reserved = append(reserved, lim.bucket)
+		if d > delay {
+			delay = d
+		}
+	}
+
+	if delay > 0 {
+		select {
+		case <-l.clock.After(delay):
+		case <-ctx.Done():
+			cancel()
+			return nil, ctx.Err()
+		}
+	}
+
+	acquired := make([]chan struct{}, 0, len(limits))
+	release := func() {
+		for _, slots := range acquired {
+			<-slots
+		}
+	}
+	for _, lim := range limits {
+		if lim.slots == nil {
+			continue
+		}
+		select {
+		case lim.slots <- struct{}{}:
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		case <-l.clock.After(deadline.Sub(l.clock.Now())):
+			release()
+			return nil, ErrRateLimited
+		}
+		acquired = append(acquired, lim.slots)
+	}
+
+	return release, nil
+}
+
+// tokenBucket lets tokens accumulate at rate up to burst. A reservation may
+// take a token in advance, leaving the bucket negative, and waits until it
+// has been refilled.
+type tokenBucket struct {
+	mux    sync.Mutex
+	clock  Clock
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// reserve takes a token and returns the time to wait before using it. No
+// token is taken if that wait would end after deadline.
+func (b *tokenBucket) reserve(deadline time.Time) (time.Duration, bool) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	now := b.clock.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	var wait time.Duration
+	if b.tokens < 1 {
+		wait = time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	}
+	if now.Add(wait).After(deadline) {
+		return 0, false
+	}
+	b.tokens--
+	return wait, true
+}
+
+// cancel returns a reserved token.
+func (b *tokenBucket) cancel() {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.tokens++
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
@@ -0,0 +1,121 @@
+package mt5client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitRecorder records the waits of the rate limiter.
+type waitRecorder struct {
+	nopMetrics
+	mux      sync.Mutex
+	waits    []time.Duration
+	rejected int
+}
+
+func (r *waitRecorder) ObserveLimiterWait(class CommandClass, wait time.Duration, err error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.waits = append(r.waits, wait)
+	if err != nil {
+		r.rejected++
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	s := newFakeServer(t, func(cmd *MT5Command) string {
+		return cmd.Name + "|RETCODE=0 Done|\r\n{}"
+	})
+	metrics := &waitRecorder{}
+	pool, err := New(s.config(), WithMetrics(metrics), WithRateLimit(Limit{Rate: 20, Burst: 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		if _, err := pool.GetCommon(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The burst goes at once, the 4 others wait 50ms each.
+	if elapsed := time.Since(start); elapsed < 180*time.Millisecond {
+		t.Errorf("6 commands sent in %s, want at least 200ms", elapsed)
+	}
+	metrics.mux.Lock()
+	defer metrics.mux.Unlock()
+	if len(metrics.waits) != 6 || metrics.rejected != 0 {
+		t.Fatalf("%d waits and %d rejections observed, want 6 and none", len(metrics.waits), metrics.rejected)
+	}
+	if metrics.waits[0] > 10*time.Millisecond {
+		t.Errorf("first command waited %s within the burst", metrics.waits[0])
+	}
+}
+
+func TestRateLimitRejects(t *testing.T) {
+	s := newFakeServer(t, func(cmd *MT5Command) string {
+		return cmd.Name + "|RETCODE=0 Done|\r\n{}"
+	})
+	metrics := &waitRecorder{}
+	pool, err := New(s.config(), WithMetrics(metrics), WithClassLimit(CommandClassRead, Limit{Rate: 0.1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	if _, err := pool.GetCommon(); err != nil {
+		t.Fatal(err)
+	}
+	// The next token comes after 10s, beyond the request timeout.
+	start := time.Now()
+	if _, err := pool.GetCommon(); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("second command returned %v, want ErrRateLimited", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("rejected after %s, want at once", elapsed)
+	}
+	if n := s.count(MT5CommandCommonGet); n != 1 {
+		t.Errorf("%d COMMON_GET sent, want the rejected one kept back", n)
+	}
+	// Other classes are not limited.
+	if _, err := pool.Do(context.Background(), "OTHER_SET", nil, nil); err != nil {
+		t.Errorf("command of another class: %v", err)
+	}
+	metrics.mux.Lock()
+	defer metrics.mux.Unlock()
+	if metrics.rejected != 1 {
+		t.Errorf("%d rejections observed, want 1", metrics.rejected)
+	}
+}
+
+func TestLimiterConcurrency(t *testing.T) {
+	l := newLimiter(newOptions([]Option{WithRateLimit(Limit{MaxConcurrent: 1})}))
+	ctx := context.Background()
+
+	release, err := l.wait(ctx, CommandClassRead, time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.wait(ctx, CommandClassRead, time.Now().Add(50*time.Millisecond)); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("second command returned %v, want ErrRateLimited while the slot is taken", err)
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := l.wait(cancelled, CommandClassRead, time.Now().Add(time.Second)); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled command returned %v", err)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		release()
+	}()
+	next, err := l.wait(ctx, CommandClassRead, time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatalf("command after the release: %v", err)
+	}
+	next()
+}
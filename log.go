@@ -0,0 +1,222 @@
+package mt5client
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Names of the structured fields attached to log records.
+const (
+	FieldConn    = "conn"
+	FieldCommand = "command"
+	FieldRetcode = "retcode"
+	FieldLatency = "latency"
+)
+
+// Fields are structured key/value pairs attached to log records.
+type Fields map[string]interface{}
+
+// Logger is the logging interface used by the pool and its connections.
+// Adapters are provided for printf-style loggers such as
+// github.com/IT-Kungfu/logger, zap's SugaredLogger, log/slog and, through
+// LoggerFunc, zerolog or any other structured logger.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+	// With returns a logger adding fields to every record.
+	With(fields Fields) Logger
+}
+
+// Level is the severity of a log record.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	}
+	return "error"
+}
+
+// LoggerFunc adapts a function receiving formatted records to Logger. With
+// zerolog, for example:
+//
+//	mt5client.LoggerFunc(func(level mt5client.Level, msg string, fields mt5client.Fields) {
+//		zl.WithLevel(zerologLevels[level]).Fields(map[string]interface{}(fields)).Msg(msg)
+//	})
+type LoggerFunc func(level Level, msg string, fields Fields)
+
+func (f LoggerFunc) Debugf(format string, args ...interface{}) {
+	f(LevelDebug, fmt.Sprintf(format, args...), nil)
+}
+
+func (f LoggerFunc) Infof(format string, args ...interface{}) {
+	f(LevelInfo, fmt.Sprintf(format, args...), nil)
+}
+
+func (f LoggerFunc) Warnf(format string, args ...interface{}) {
+	f(LevelWarn, fmt.Sprintf(format, args...), nil)
+}
+
+func (f LoggerFunc) Errorf(format string, args ...interface{}) {
+	f(LevelError, fmt.Sprintf(format, args...), nil)
+}
+
+func (f LoggerFunc) With(fields Fields) Logger {
+	return &fieldsLogger{
+		fields: fields,
+		log: func(level Level, format string, args []interface{}, fields Fields) {
+			f(level, fmt.Sprintf(format, args...), fields)
+		},
+	}
+}
+
+// Printf is a logger with printf-style methods per level, like
+// *logger.Logger from github.com/IT-Kungfu/logger or logrus.
+type Printf interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// PrintfLogger adapts a printf-style logger. Fields are appended to the
+// message as key=value pairs. The message is only formatted if the logger
+// writes it.
+func PrintfLogger(l Printf) Logger {
+	return &fieldsLogger{
+		log: func(level Level, format string, args []interface{}, fields Fields) {
+			if len(fields) > 0 {
+				format += "%s"
+				args = append(args[:len(args):len(args)], formattedFields(fields))
+			}
+			switch level {
+			case LevelDebug:
+				l.Debugf(format, args...)
+			case LevelInfo:
+				l.Infof(format, args...)
+			case LevelWarn:
+				l.Warnf(format, args...)
+			default:
+				l.Errorf(format, args...)
+			}
+		},
+	}
+}
+
+// Sugared is a logger taking a message and alternating keys and values,
+// like zap's *SugaredLogger.
+type Sugared interface {
+	Debugw(msg string, keysAndValues ...interface{})
+	Infow(msg string, keysAndValues ...interface{})
+	Warnw(msg string, keysAndValues ...interface{})
+	Errorw(msg string, keysAndValues ...interface{})
+}
+
+// SugaredLogger adapts a zap-style sugared logger.
+func SugaredLogger(l Sugared) Logger {
+	return &fieldsLogger{
+		log: func(level Level, format string, args []interface{}, fields Fields) {
+			msg := fmt.Sprintf(format, args...)
+			kv := make([]interface{}, 0, 2*len(fields))
+			for _, k := range sortedKeys(fields) {
+				kv = append(kv, k, fields[k])
+			}
+			switch level {
+			case LevelDebug:
+				l.Debugw(msg, kv...)
+			case LevelInfo:
+				l.Infow(msg, kv...)
+			case LevelWarn:
+				l.Warnw(msg, kv...)
+			default:
+				l.Errorw(msg, kv...)
+			}
+		},
+	}
+}
+
+type nopLogger struct{}
+
+// NopLogger discards all records.
+func NopLogger() Logger {
+	return nopLogger{}
+}
+
+func (nopLogger) Debugf(string, ...interface{}) {}
+
+func (nopLogger) Infof(string, ...interface{}) {}
+
+func (nopLogger) Warnf(string, ...interface{}) {}
+
+func (nopLogger) Errorf(string, ...interface{}) {}
+
+func (l nopLogger) With(Fields) Logger {
+	return l
+}
+
+// fieldsLogger collects the fields for an adapter.
+type fieldsLogger struct {
+	fields Fields
+	log    func(level Level, format string, args []interface{}, fields Fields)
+}
+
+func (l *fieldsLogger) Debugf(format string, args ...interface{}) {
+	l.log(LevelDebug, format, args, l.fields)
+}
+
+func (l *fieldsLogger) Infof(format string, args ...interface{}) {
+	l.log(LevelInfo, format, args, l.fields)
+}
+
+func (l *fieldsLogger) Warnf(format string, args ...interface{}) {
+	l.log(LevelWarn, format, args, l.fields)
+}
+
+func (l *fieldsLogger) Errorf(format string, args ...interface{}) {
+	l.log(LevelError, format, args, l.fields)
+}
+
+func (l *fieldsLogger) With(fields Fields) Logger {
+	merged := make(Fields, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &fieldsLogger{fields: merged, log: l.log}
+}
+
+type formattedFields Fields
+
+func (fields formattedFields) String() string {
+	var b strings.Builder
+	for _, k := range sortedKeys(Fields(fields)) {
+		fmt.Fprintf(&b, " %s=%v", k, fields[k])
+	}
+	return b.String()
+}
+
+func sortedKeys(fields Fields) []string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,57 @@
+//go:build go1.21
+// +build go1.21
+
+package mt5client
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+var slogLevels = map[Level]slog.Level{
+	LevelDebug: slog.LevelDebug,
+	LevelInfo:  slog.LevelInfo,
+	LevelWarn:  slog.LevelWarn,
+	LevelError: slog.LevelError,
+}
+
+// SlogLogger adapts a log/slog logger, fields become record attributes.
+func SlogLogger(l *slog.Logger) Logger {
+	return &slogLogger{l: l}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (s *slogLogger) log(level Level, format string, args []interface{}) {
+	ctx := context.Background()
+	if s.l.Enabled(ctx, slogLevels[level]) {
+		s.l.Log(ctx, slogLevels[level], fmt.Sprintf(format, args...))
+	}
+}
+
+func (s *slogLogger) Debugf(format string, args ...interface{}) {
+	s.log(LevelDebug, format, args)
+}
+
+func (s *slogLogger) Infof(format string, args ...interface{}) {
+	s.log(LevelInfo, format, args)
+}
+
+func (s *slogLogger) Warnf(format string, args ...interface{}) {
+	s.log(LevelWarn, format, args)
+}
+
+func (s *slogLogger) Errorf(format string, args ...interface{}) {
+	s.log(LevelError, format, args)
+}
+
+func (s *slogLogger) With(fields Fields) Logger {
+	attrs := make([]interface{}, 0, len(fields))
+	for _, k := range sortedKeys(fields) {
+		attrs = append(attrs, slog.Any(k, fields[k]))
+	}
+	return &slogLogger{l: s.l.With(attrs...)}
+}
@@ -0,0 +1,26 @@
+//go:build go1.21
+// +build go1.21
+
+package mt5client
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSlogLogger(t *testing.T) {
+	var b bytes.Buffer
+	l := SlogLogger(slog.New(slog.NewTextHandler(&b, &slog.HandlerOptions{Level: slog.LevelInfo})))
+	l.Debugf("hidden %d", 1)
+	l.With(Fields{FieldConn: 3}).Warnf("slow %s", "USER_GET")
+
+	out := b.String()
+	if strings.Contains(out, "hidden") {
+		t.Errorf("debug record written below the level: %s", out)
+	}
+	if !strings.Contains(out, `level=WARN msg="slow USER_GET" conn=3`) {
+		t.Errorf("slog record %q, want the message and the conn attribute", out)
+	}
+}
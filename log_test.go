@@ -0,0 +1,66 @@
+package mt5client
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// printfRecorder is a printf-style logger keeping the formatted records.
+type printfRecorder struct {
+	records []string
+}
+
+func (r *printfRecorder) add(level, format string, args ...interface{}) {
+	r.records = append(r.records, level+" "+fmt.Sprintf(format, args...))
+}
+
+func (r *printfRecorder) Debugf(format string, args ...interface{}) { r.add("debug", format, args...) }
+func (r *printfRecorder) Infof(format string, args ...interface{})  { r.add("info", format, args...) }
+func (r *printfRecorder) Warnf(format string, args ...interface{})  { r.add("warn", format, args...) }
+func (r *printfRecorder) Errorf(format string, args ...interface{}) { r.add("error", format, args...) }
+
+// sugaredRecorder is a zap-style sugared logger keeping the records.
+type sugaredRecorder struct {
+	records [][]interface{}
+}
+
+func (r *sugaredRecorder) add(level, msg string, kv []interface{}) {
+	r.records = append(r.records, append([]interface{}{level, msg}, kv...))
+}
+
+func (r *sugaredRecorder) Debugw(msg string, kv ...interface{}) { r.add("debug", msg, kv) }
+func (r *sugaredRecorder) Infow(msg string, kv ...interface{})  { r.add("info", msg, kv) }
+func (r *sugaredRecorder) Warnw(msg string, kv ...interface{})  { r.add("warn", msg, kv) }
+func (r *sugaredRecorder) Errorw(msg string, kv ...interface{}) { r.add("error", msg, kv) }
+
+func TestLoggerAdapters(t *testing.T) {
+	printf := &printfRecorder{}
+	l := PrintfLogger(printf)
+	l.Infof("%d%% done", 50)
+	l.With(Fields{FieldConn: 1}).With(Fields{FieldCommand: "USER_GET"}).Warnf("slow %s", "response")
+	want := []string{"info 50% done", "warn slow response command=USER_GET conn=1"}
+	if !reflect.DeepEqual(printf.records, want) {
+		t.Errorf("PrintfLogger wrote %q, want %q", printf.records, want)
+	}
+
+	sugared := &sugaredRecorder{}
+	SugaredLogger(sugared).With(Fields{FieldRetcode: "0 Done", FieldConn: 2}).Errorf("failed: %v", "EOF")
+	wantKV := [][]interface{}{{"error", "failed: EOF", FieldConn, 2, FieldRetcode, "0 Done"}}
+	if !reflect.DeepEqual(sugared.records, wantKV) {
+		t.Errorf("SugaredLogger wrote %v, want %v", sugared.records, wantKV)
+	}
+
+	var got []string
+	f := LoggerFunc(func(level Level, msg string, fields Fields) {
+		got = append(got, fmt.Sprintf("%s %s %v", level, msg, fields))
+	})
+	f.Debugf("a %d", 1)
+	f.With(Fields{FieldLatency: "1ms"}).Errorf("b")
+	want = []string{"debug a 1 map[]", "error b map[latency:1ms]"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LoggerFunc received %q, want %q", got, want)
+	}
+
+	NopLogger().With(Fields{FieldConn: 1}).Errorf("discarded")
+}
@@ -0,0 +1,45 @@
+package mt5client
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SendMail delivers an internal mail message to the client terminals.
+// Recipients are logins and/or group masks, for example "1001", "demo\*".
+func (p *Pool) SendMail(to []string, subject, body string) error {
+	resp, err := p.call(&ClientControlMessage{
+		Cmd: &MT5Command{
+			Name: MT5CommandMailSend,
+			Params: map[string]string{
+				"TO":      strings.Join(to, ","),
+				"SUBJECT": subject,
+			},
+			Payload: body,
+		},
+	}, p.requestTimeout())
+	if err != nil {
+		return err
+	}
+	return resp.Err
+}
+
+// SendNews publishes a news item. Language is the Windows LANGID of the news,
+// 0 means the news is shown for every terminal language.
+func (p *Pool) SendNews(category, subject, body string, language uint32) error {
+	resp, err := p.call(&ClientControlMessage{
+		Cmd: &MT5Command{
+			Name: MT5CommandNewsSend,
+			Params: map[string]string{
+				"CATEGORY": category,
+				"SUBJECT":  subject,
+				"LANGUAGE": fmt.Sprintf("%d", language),
+			},
+			Payload: body,
+		},
+	}, p.requestTimeout())
+	if err != nil {
+		return err
+	}
+	return resp.Err
+}
@@ -0,0 +1,48 @@
+package mt5client
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestSendMailAndNews(t *testing.T) {
+	var mux sync.Mutex
+	received := make(map[string]*MT5Command)
+	s := newFakeServer(t, func(cmd *MT5Command) string {
+		if cmd.Params["TO"] == "unknown" {
+			return cmd.Name + "|RETCODE=13 Not found|\r\n"
+		}
+		mux.Lock()
+		received[cmd.Name] = cmd
+		mux.Unlock()
+		return cmd.Name + "|RETCODE=0 Done|\r\n"
+	})
+	pool, err := New(s.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	subject := `Margin call | level=50% \ act now`
+	body := "Dear client,\r\nyour margin level is low.|=\\"
+	if err := pool.SendMail([]string{"1001", `demo\*`}, subject, body); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.SendNews("Markets", subject, body, 1049); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.SendMail([]string{"unknown"}, "subject", "body"); err == nil {
+		t.Error("SendMail to an unknown login succeeded")
+	}
+
+	mux.Lock()
+	defer mux.Unlock()
+	mail := received[MT5CommandMailSend]
+	if mail == nil || mail.Params["SUBJECT"] != subject || mail.Payload != body {
+		t.Errorf("MAIL_SEND received as %+v", mail)
+	}
+	news := received[MT5CommandNewsSend]
+	if news == nil || news.Params["SUBJECT"] != subject || news.Params["LANGUAGE"] != "1049" || news.Payload != body {
+		t.Errorf("NEWS_SEND received as %+v", news)
+	}
+}
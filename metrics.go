@@ -0,0 +1,151 @@
+package mt5client
+
+import (
+	"context"
+	"time"
+)
+
+// Metrics receives the measurements of the pool, see NewPrometheusMetrics.
+// Implementations must be safe for concurrent use.
+type Metrics interface {
+	// ObserveCommand is called once for every command exchanged with the
+	// server, including the failed ones.
+	ObserveCommand(o *CommandObservation)
+	// ObservePool is called once by every pool created with these metrics.
+	// stats returns the current state of the pool and is meant to be read
+	// when the gauges are collected. The pool calls unregister when it is
+	// closed, stats must not be read afterwards.
+	ObservePool(stats func() PoolStats) (unregister func())
+	// ObserveLimiterWait is called for every command passing the rate
+	// limiter, see WithRateLimit. err is set if the command was rejected.
+	ObserveLimiterWait(class CommandClass, wait time.Duration, err error)
+}
+
+// CommandObservation describes a single command exchange.
+type CommandObservation struct {
+	Command string
+	// Retcode is empty when no response was received.
+	Retcode       string
+	Latency       time.Duration
+	BytesSent     int
+	BytesReceived int
+	// Chunks is the number of packets the response was split into.
+	Chunks int
+	// Err is the transport error, if any.
+	Err error
+}
+
+// Retcode classes reported by CommandObservation.RetcodeClass.
+const (
+	RetcodeClassOK        = "ok"
+	RetcodeClassError     = "error"
+	RetcodeClassTransport = "transport"
+)
+
+// RetcodeClass groups the result of the command: RetcodeClassOK for a
+// successful retcode, RetcodeClassError for any other retcode and
+// RetcodeClassTransport when no response was received.
+func (o *CommandObservation) RetcodeClass() string {
+	if o.Err != nil || o.Retcode == "" {
+		return RetcodeClassTransport
+	}
+	if retcodeNumber(o.Retcode) == 0 {
+		return RetcodeClassOK
+	}
+	return RetcodeClassError
+}
+
+// PoolStats is the state of a pool read by the gauges.
+type PoolStats struct {
+	Connections map[ConnState]int
+	// QueueDepth is the number of commands dispatched to the connections
+	// and not handled yet.
+	QueueDepth int
+	// OutboxDepth is the number of responses of asynchronous calls not read
+	// yet, OutboxDropped the number of them dropped so far because the
+	// outbox was full, see WithOutboxCapacity.
+	OutboxDepth   int
+	OutboxDropped uint64
+}
+
+type nopMetrics struct{}
+
+// NopMetrics discards all measurements.
+func NopMetrics() Metrics {
+	return nopMetrics{}
+}
+
+func (nopMetrics) ObserveCommand(*CommandObservation) {}
+
+func (nopMetrics) ObservePool(func() PoolStats) func() { return func() {} }
+
+func (nopMetrics) ObserveLimiterWait(CommandClass, time.Duration, error) {}
+
+// stats returns the connections by state and the commands in flight.
+func (p *Pool) stats() PoolStats {
+	h := p.Health()
+	s := PoolStats{
+		Connections: make(map[ConnState]int),
+	}
+	for _, c := range h.Connections {
+		s.Connections[c.State]++
+		s.QueueDepth += c.InFlight
+	}
+	s.OutboxDepth, s.OutboxDropped = p.outbox.stats()
+	return s
+}
+
+// wireStats counts the traffic of a command exchange. start is set once the
+// request is sent over a connection.
+type wireStats struct {
+	start    time.Time
+	sent     int
+	received int
+	chunks   int
+}
+
+type wireStatsKey struct{}
+
+// wireStatsFrom returns the stats of the exchange set by measure on ctx.
+func wireStatsFrom(ctx context.Context) *wireStats {
+	if stats, ok := ctx.Value(wireStatsKey{}).(*wireStats); ok {
+		return stats
+	}
+	return &wireStats{}
+}
+
+// measure reports the exchanges sent over the wire to the metrics, not the
+// commands answered without being sent. A streamed response is reported once
+// its payload is drained.
+func (c *MT5Client) measure(ctx context.Context, cmd *MT5Command, next Invoker) (*MT5Command, error) {
+	stats := &wireStats{}
+	resp, err := next(context.WithValue(ctx, wireStatsKey{}, stats), cmd)
+	if stats.start.IsZero() {
+		return resp, err
+	}
+
+	if s, ok := ctx.Value(streamKey{}).(*stream); ok && s.payload != nil {
+		s.payload.drained = func(err error) {
+			c.observe(cmd, resp, stats, err)
+		}
+		return resp, err
+	}
+	c.observe(cmd, resp, stats, err)
+	return resp, err
+}
+
+// observe reports an exchange of cmd, resp is nil if no response was read.
+func (c *MT5Client) observe(cmd, resp *MT5Command, stats *wireStats, err error) {
+	o := &CommandObservation{
+		Command:       cmd.Name,
+		Latency:       c.clock.Now().Sub(stats.start),
+		BytesSent:     stats.sent,
+		BytesReceived: stats.received,
+		Chunks:        stats.chunks,
+		Err:           err,
+	}
+	if resp != nil {
+		o.Retcode = resp.Params[MT5RetCode]
+	}
+	c.metrics.ObserveCommand(o)
+}
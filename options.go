@@ -0,0 +1,139 @@
+package mt5client
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// Option configures the pool created by New.
+type Option func(*options)
+
+// Dialer opens the TCP connections to the MT5 server. *net.Dialer satisfies
+// it, a custom one may add proxies, TLS tunnels or fake servers in tests.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// Clock is the source of time for timeouts, backoff and health timestamps.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type options struct {
+	log      Logger
+	redactor *Redactor
+	email    EmailPolicy
+	dialer   Dialer
+
+	limit        Limit
+	classLimits  map[CommandClass]Limit
+	breakers     map[CommandClass]Breaker
+	retry        RetryPolicy
+	delete       DeletePolicy
+	interceptors []Interceptor
+	auditor      Auditor
+	recycleBin   RecycleBin
+	dryRun       bool
+	// outboxCapacity limits the queued responses, see WithOutboxCapacity.
+	outboxCapacity int
+	clock          Clock
+	metrics        Metrics
+	tracer         Tracer
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		log:            NopLogger(),
+		redactor:       NewRedactor(),
+		dialer:         &net.Dialer{KeepAlive: 30 * time.Second},
+		clock:          systemClock{},
+		metrics:        NopMetrics(),
+		tracer:         NopTracer(),
+		retry:          DefaultRetryPolicy,
+		outboxCapacity: DefaultOutboxCapacity,
+		breakers: map[CommandClass]Breaker{
+			CommandClassTradeWrite: DefaultBreaker,
+			CommandClassUserWrite:  DefaultBreaker,
+		},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithLogger sets the logger of the pool and its connections, nothing is
+// logged by default. Use PrintfLogger for github.com/IT-Kungfu/logger.
+func WithLogger(log Logger) Option {
+	return func(o *options) {
+		if log != nil {
+			o.log = log
+		}
+	}
+}
+
+// WithRedactor sets how secrets and personal data are masked in the logged
+// commands, NewRedactor() by default. A nil redactor disables masking.
+func WithRedactor(r *Redactor) Option {
+	return func(o *options) {
+		o.redactor = r
+	}
+}
+
+// WithEmailPolicy sets how AddUser and UpdateUser store e-mail addresses,
+// EmailMask by default.
+func WithEmailPolicy(policy EmailPolicy) Option {
+	return func(o *options) {
+		o.email = policy
+	}
+}
+
+// WithDialer replaces the default *net.Dialer.
+func WithDialer(d Dialer) Option {
+	return func(o *options) {
+		if d != nil {
+			o.dialer = d
+		}
+	}
+}
+
+// WithClock replaces the system clock.
+func WithClock(c Clock) Option {
+	return func(o *options) {
+		if c != nil {
+			o.clock = c
+		}
+	}
+}
+
+// WithMetrics sets where the pool reports its measurements, they are
+// discarded by default.
+func WithMetrics(m Metrics) Option {
+	return func(o *options) {
+		if m != nil {
+			o.metrics = m
+		}
+	}
+}
+
+// WithTracer sets the tracer creating spans for pool calls, none are created
+// by default.
+func WithTracer(t Tracer) Option {
+	return func(o *options) {
+		if t != nil {
+			o.tracer = t
+		}
+	}
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
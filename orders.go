@@ -1,24 +1,24 @@
 package mt5client
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"strconv"
 	"strings"
 )
 
 func (p *Pool) GetOrdersTotal(login string) {
-	p.getClient().controlCh <- &ClientControlMessage{
+	p.dispatch(&ClientControlMessage{
 		Cmd: &MT5Command{
 			Name:   MT5CommandOrderGetTotal,
 			Params: map[string]string{"LOGIN": login},
 		},
 		Cb: p.cb,
-	}
+	})
 }
 
 func (p *Pool) GetOrdersHistoryTotal(login string, from, to int64) {
-	p.getClient().controlCh <- &ClientControlMessage{
+	p.dispatch(&ClientControlMessage{
 		Cmd: &MT5Command{
 			Name: MT5CommandOrderGetHistoryTotal,
 			Params: map[string]string{
@@ -28,11 +28,11 @@ func (p *Pool) GetOrdersHistoryTotal(login string, from, to int64) {
 			},
 		},
 		Cb: p.cb,
-	}
+	})
 }
 
 func (p *Pool) GetOrdersPage(login string, offset, total int) {
-	p.getClient().controlCh <- &ClientControlMessage{
+	p.dispatch(&ClientControlMessage{
 		Cmd: &MT5Command{
 			Name: MT5CommandOrderGetPage,
 			Params: map[string]string{
@@ -42,11 +42,11 @@ func (p *Pool) GetOrdersPage(login string, offset, total int) {
 			},
 		},
 		Cb: p.cb,
-	}
+	})
 }
 
 func (p *Pool) GetOrdersBatch(login, group, ticket []string) {
-	p.getClient().controlCh <- &ClientControlMessage{
+	p.dispatch(&ClientControlMessage{
 		Cmd: &MT5Command{
 			Name: MT5CommandOrderGetBatch,
 			Params: map[string]string{
@@ -56,11 +56,11 @@ func (p *Pool) GetOrdersBatch(login, group, ticket []string) {
 			},
 		},
 		Cb: p.cb,
-	}
+	})
 }
 
 func (p *Pool) GetOrdersHistoryPage(login string, from, to int64, offset, total int) {
-	p.getClient().controlCh <- &ClientControlMessage{
+	p.dispatch(&ClientControlMessage{
 		Cmd: &MT5Command{
 			Name: MT5CommandOrderGetHistoryPage,
 			Params: map[string]string{
@@ -72,11 +72,11 @@ func (p *Pool) GetOrdersHistoryPage(login string, from, to int64, offset, total
 			},
 		},
 		Cb: p.cb,
-	}
+	})
 }
 
 func (p *Pool) GetOrdersHistoryBatch(login, group, ticket []string, from, to int64) {
-	p.getClient().controlCh <- &ClientControlMessage{
+	p.dispatch(&ClientControlMessage{
 		Cmd: &MT5Command{
 			Name: MT5CommandOrderGetHistoryBatch,
 			Params: map[string]string{
@@ -88,70 +88,68 @@ func (p *Pool) GetOrdersHistoryBatch(login, group, ticket []string, from, to int
 			},
 		},
 		Cb: p.cb,
-	}
+	})
 }
 
-func (c *MT5Client) getOrdersTotal(m *ClientControlMessage) {
-	req, body, err := c.makeRequest(m.Cmd)
-	if err != nil {
-		m.Cb <- &ClientResponse{Cmd: m.Cmd, Err: err}
-		return
-	}
-
-	c.log.Debugf("#%d %s header: %s body: %s", c.clientId, m.Cmd.Name, req[:MT5HeaderLength], body)
-
-	cmd, err := c.sendRequest(req)
+// StreamOrdersBatch works like GetOrdersBatch but calls fn for every order as
+// soon as it is decoded from the response.
+func (p *Pool) StreamOrdersBatch(ctx context.Context, login, group, ticket []string, fn func(o *Order) error) error {
+	resp, err := p.callContext(ctx, &ClientControlMessage{
+		Cmd: &MT5Command{
+			Name: MT5CommandOrderGetBatch,
+			Params: map[string]string{
+				"LOGIN":  strings.Join(login, ","),
+				"GROUP":  strings.Join(group, ","),
+				"TICKET": strings.Join(ticket, ","),
+			},
+		},
+		Stream: func(item interface{}) error {
+			return fn(item.(*Order))
+		},
+	}, p.requestTimeout())
 	if err != nil {
-		m.Cb <- &ClientResponse{Cmd: m.Cmd, Err: err}
-		return
-	}
-
-	if cmd.Params[MT5RetCode] != MT5RetCodeSuccess {
-		m.Cb <- &ClientResponse{Cmd: m.Cmd, Err: fmt.Errorf("%s retcode error: %s", m.Cmd.Name, cmd.Params[MT5RetCode])}
-		return
-	}
-
-	c.log.Debugf("%s response: %+v", cmd.Name, cmd.Params)
-
-	total, err := strconv.Atoi(cmd.Params["TOTAL"])
-	m.Cb <- &ClientResponse{
-		Cmd:      m.Cmd,
-		Response: &OrdersTotalResponse{Total: total},
-		Err:      err,
+		return err
 	}
+	return resp.Err
 }
 
-func (c *MT5Client) getOrders(m *ClientControlMessage) {
-	req, body, err := c.makeRequest(m.Cmd)
-	if err != nil {
-		m.Cb <- &ClientResponse{Cmd: m.Cmd, Err: err}
-		return
-	}
-
-	c.log.Debugf("#%d %s header: %s body: %s...", c.clientId, m.Cmd.Name, req[:MT5HeaderLength], body[:32])
-
-	cmd, err := c.sendRequest(req)
+// StreamOrdersHistoryBatch works like GetOrdersHistoryBatch but calls fn for
+// every order as soon as it is decoded from the response.
+func (p *Pool) StreamOrdersHistoryBatch(ctx context.Context, login, group, ticket []string, from, to int64, fn func(o *Order) error) error {
+	resp, err := p.callContext(ctx, &ClientControlMessage{
+		Cmd: &MT5Command{
+			Name: MT5CommandOrderGetHistoryBatch,
+			Params: map[string]string{
+				"LOGIN":  strings.Join(login, ","),
+				"GROUP":  strings.Join(group, ","),
+				"TICKET": strings.Join(ticket, ","),
+				"FROM":   fmt.Sprintf("%d", from),
+				"TO":     fmt.Sprintf("%d", to),
+			},
+		},
+		Stream: func(item interface{}) error {
+			return fn(item.(*Order))
+		},
+	}, p.requestTimeout())
 	if err != nil {
-		m.Cb <- &ClientResponse{Cmd: m.Cmd, Err: err}
-		return
-	}
-
-	if cmd.Params[MT5RetCode] != MT5RetCodeSuccess {
-		m.Cb <- &ClientResponse{Cmd: m.Cmd, Err: fmt.Errorf("%s retcode error: %s", m.Cmd.Name, cmd.Params[MT5RetCode])}
-		return
+		return err
 	}
+	return resp.Err
+}
 
-	c.log.Debugf("#%d %s response: %+v", c.clientId, cmd.Name, cmd.Params)
+func decodeOrdersTotal(x *Exchange) (interface{}, error) {
+	total, err := strconv.Atoi(x.Response.Params["TOTAL"])
+	return &OrdersTotalResponse{Total: total}, err
+}
 
+func decodeOrders(x *Exchange) (interface{}, error) {
 	orders := make([]Order, 0, 100)
-	err = json.Unmarshal([]byte(cmd.Payload), &orders)
-
-	c.log.Infof("#%d Orders received: %d", c.clientId, len(orders))
-
-	m.Cb <- &ClientResponse{
-		Cmd:      m.Cmd,
-		Response: &OrdersResponse{Orders: orders},
-		Err:      err,
-		ClientId: c.clientId,
+	if err := x.Unmarshal(&orders); err != nil {
+		return nil, err
 	}
+	return &OrdersResponse{Orders: orders}, nil
+}
+
+func newOrder() interface{} {
+	return &Order{}
 }
@@ -2,12 +2,49 @@ package mt5client
 
 import (
 	"context"
-	"github.com/IT-Kungfu/logger"
+	"errors"
+	"sync"
+	"sync/atomic"
 )
 
 type ClientControlMessage struct {
 	Cmd *MT5Command
 	Cb  chan *ClientResponse
+	// Stream, when set, makes batch commands decode the response
+	// incrementally and pass every item to it instead of collecting them.
+	// It is called from the connection goroutine.
+	Stream func(item interface{}) error
+
+	// ctx carries the span of the pool call, see Pool.WithContext, parent
+	// is the context the call was made with.
+	ctx    context.Context
+	parent context.Context
+	span   Span
+	// release frees the rate limiter slots taken by the message.
+	release func()
+	// breaker is the circuit breaker of the command class, the first
+	// outcome of the message is reported to it.
+	breaker  *breaker
+	reported int32
+	handled  chan struct{}
+	// err is the error of the response, set by the connection.
+	err error
+	// retry sends the message again after a failed attempt, see Pool.retry.
+	// attempt counts the previous attempts, tried lists their connections.
+	retry   func(m *ClientControlMessage, clientId int, err error) bool
+	attempt int
+	tried   []int
+	// call is shared by the attempts of a call whose caller may abandon it,
+	// see Pool.callContext.
+	call *callState
+	// outbox queues the response of an asynchronous call for Cb, see
+	// Pool.dispatch.
+	outbox *outbox
+	// raw passes the response command as it is, see Pool.Do.
+	raw bool
+	// streamed is set once items were passed to Stream, the message is not
+	// retried then.
+	streamed bool
 }
 
 type ClientResponse struct {
@@ -15,69 +52,363 @@ type ClientResponse struct {
 	Response interface{}
 	Err      error
 	ClientId int
+	// Simulated is set if the command was not sent to the server, see
+	// WithDryRun.
+	Simulated bool
 }
 
 type Client struct {
+	// lastUsed is the unix time in nanoseconds of the last dispatch, first to
+	// keep it 64-bit aligned for atomic access.
+	lastUsed  int64
 	handler   *MT5Client
 	controlCh chan *ClientControlMessage
 }
 
 type Pool struct {
-	cfg        *Config
-	log        *logger.Logger
-	clients    []*Client
-	cb         chan *ClientResponse
-	poolSize   int
-	nextClient int
+	cfg         *Config
+	log         Logger
+	redactor    *Redactor
+	emailPolicy EmailPolicy
+	opts        *options
+	clock       Clock
+	tracer      Tracer
+	// ctx is the parent of the call spans, see WithContext.
+	ctx         context.Context
+	clients     *clientSet
+	cb          chan *ClientResponse
+	poolSize    int
+	minSize     int
+	balancer    Balancer
+	limiter     *limiter
+	breakers    map[CommandClass]*breaker
+	retryPolicy RetryPolicy
+	events      chan *ConnStateEvent
+	subscribers *subscribers
+	// dialCtx is cancelled when the pool is closed to abort dials in the
+	// background.
+	dialCtx    context.Context
+	cancelDial context.CancelFunc
+	// unobserve stops the metrics reading the stats of the closed pool.
+	unobserve func()
+	outbox    *outbox
+	// confirmKey signs the confirmation tokens of the deletes.
+	confirmKey []byte
+	// closeOnce is shared with the copies made by WithContext, any of them
+	// may close the pool.
+	closeOnce *sync.Once
+	done      chan struct{}
 }
 
-func NewMT5ClientPool(ctx context.Context, poolSize int) (*Pool, error) {
-	services := ctx.Value("services").(map[string]interface{})
-	cb := make(chan *ClientResponse, poolSize)
+// New creates a pool of up to cfg.MT5PoolSize connections. MT5PoolMinSize
+// connections (all of them if not set) are dialed in parallel right away, the
+// pool starts if at least one of them succeeds. More connections are dialed
+// on demand.
+func New(cfg Config, opts ...Option) (*Pool, error) {
+	return Dial(context.Background(), cfg, opts...)
+}
+
+// Dial is like New, ctx cancels dialing and authenticating the initial
+// connections. It is not used once Dial returns.
+func Dial(ctx context.Context, cfg Config, opts ...Option) (*Pool, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	o := newOptions(opts)
+
+	if cfg.MT5PoolSize == 0 {
+		cfg.MT5PoolSize = 1
+	}
+	minSize := cfg.MT5PoolMinSize
+	if minSize == 0 {
+		minSize = cfg.MT5PoolSize
+	}
+
+	confirmKey, err := newConfirmKey()
+	if err != nil {
+		return nil, err
+	}
+
+	dialCtx, cancelDial := context.WithCancel(context.Background())
 	pool := &Pool{
-		cfg:      services["mt5cfg"].(*Config),
-		log:      services["log"].(*logger.Logger),
-		clients:  make([]*Client, 0, poolSize),
-		poolSize: poolSize,
-		cb:       cb,
+		cfg:         &cfg,
+		log:         o.log,
+		redactor:    o.redactor,
+		emailPolicy: o.email,
+		opts:        o,
+		clock:       o.clock,
+		tracer:      o.tracer,
+		clients:     &clientSet{},
+		poolSize:    cfg.MT5PoolSize,
+		minSize:     minSize,
+		balancer:    SkipUnhealthy(LeastInFlight()),
+		limiter:     newLimiter(o),
+		breakers:    newBreakers(o),
+		retryPolicy: o.retry,
+		cb:          make(chan *ClientResponse, cfg.MT5PoolSize),
+		events:      make(chan *ConnStateEvent, eventsBufferSize),
+		subscribers: newSubscribers(),
+		dialCtx:     dialCtx,
+		cancelDial:  cancelDial,
+		outbox:      newOutbox(o.outboxCapacity),
+		confirmKey:  confirmKey,
+		closeOnce:   &sync.Once{},
+		done:        make(chan struct{}),
+	}
+	go pool.broadcast()
+	go pool.outbox.forward(pool.done)
+	pool.unobserve = o.metrics.ObservePool(pool.stats)
+
+	added, err := pool.grow(ctx, minSize)
+	if added == 0 {
+		pool.Close()
+		return nil, err
+	}
+	if err != nil {
+		pool.log.Errorf("MT5 pool started with %d of %d connections: %v", added, minSize, err)
+	}
+
+	go pool.maintain()
+
+	return pool, nil
+}
+
+// NewMT5ClientPool creates a pool of up to poolSize connections from the
+// "mt5cfg" *Config and the "log" printf-style logger found in the "services" map of
+// ctx.
+//
+// Deprecated: use New or Dial.
+func NewMT5ClientPool(ctx context.Context, poolSize int) (*Pool, error) {
+	services, ok := ctx.Value("services").(map[string]interface{})
+	if !ok {
+		return nil, errors.New("mt5client: context has no services map")
+	}
+	cfg, ok := services["mt5cfg"].(*Config)
+	if !ok || cfg == nil {
+		return nil, errors.New("mt5client: services have no mt5cfg *Config")
+	}
+	log, ok := services["log"].(Printf)
+	if !ok || log == nil {
+		return nil, errors.New("mt5client: services have no printf-style log")
+	}
+
+	c := *cfg
+	c.MT5PoolSize = poolSize
+	if c.MT5PoolMinSize > poolSize {
+		c.MT5PoolMinSize = poolSize
 	}
+	return Dial(ctx, c, WithLogger(PrintfLogger(log)))
+}
+
+func (p *Pool) Response() chan *ClientResponse {
+	return p.cb
+}
 
-	for i := 0; i < poolSize; i++ {
-		controlCh := make(chan *ClientControlMessage)
-		services["controlCh"] = controlCh
-		services["clientId"] = i
-		clientCtx := context.WithValue(ctx, "services", services)
+// SetBalancer replaces the strategy choosing the connection for each command,
+// SkipUnhealthy(LeastInFlight()) by default. It must be called before the
+// pool is used.
+func (p *Pool) SetBalancer(b Balancer) {
+	p.balancer = b
+}
 
-		h, err := NewMT5Client(clientCtx)
+// acquire chooses the connection for cmd with the balancer, avoiding the
+// connections in tried if possible, and marks the command in flight on it.
+// Another connection is dialed in the background when all connections are
+// busy and the pool is not at its maximum size. Without connections it fails
+// right away, never dialing on the request path.
+func (p *Pool) acquire(cmd *MT5Command, tried []int) (*Client, error) {
+	p.clients.mux.RLock()
+	if len(p.clients.list) == 0 {
+		err := p.dialBlockedLocked()
+		p.clients.mux.RUnlock()
 		if err != nil {
 			return nil, err
 		}
-		c := &Client{
-			handler:   h,
-			controlCh: controlCh,
+		go func() {
+			_, _ = p.grow(p.dialCtx, 1)
+		}()
+		return nil, ErrNoConnections
+	}
+
+	conns := make([]ConnHealth, 0, len(p.clients.list))
+	busy := true
+	for _, c := range p.clients.list {
+		h := c.handler.health()
+		if h.State == StateConnected && h.InFlight == 0 {
+			busy = false
 		}
-		pool.clients = append(pool.clients, c)
+		conns = append(conns, h)
 	}
 
-	return pool, nil
+	var i int
+	if len(tried) == 0 {
+		i = p.balancer.Pick(ClassOf(cmd.Name), conns)
+	} else {
+		i = pickAmong(p.balancer, ClassOf(cmd.Name), conns, func(c *ConnHealth) bool {
+			for _, id := range tried {
+				if c.ClientId == id {
+					return false
+				}
+			}
+			return true
+		})
+	}
+	if i < 0 || i >= len(p.clients.list) {
+		i = 0
+	}
+	c := p.clients.list[i]
+	atomic.AddInt64(&c.handler.inFlight, 1)
+	atomic.StoreInt64(&c.lastUsed, p.clock.Now().UnixNano())
+
+	canGrow := len(p.clients.list)+p.clients.growing < p.poolSize
+	p.clients.mux.RUnlock()
+
+	if busy && canGrow {
+		go func() {
+			_, _ = p.grow(p.dialCtx, 1)
+		}()
+	}
+
+	return c, nil
 }
 
-func (p *Pool) Response() chan *ClientResponse {
-	return p.cb
+// dispatch sends the message to the connection chosen by the balancer. The
+// message counts as in flight until the connection has handled it. Its
+// response is queued for Cb, usually Response, so the connection never waits
+// for the reader. The responses not read when the pool is closed or not
+// fitting in the outbox are dropped, see WithOutboxCapacity.
+func (p *Pool) dispatch(m *ClientControlMessage) {
+	m.outbox = p.outbox
+	p.send(p.context(), m)
 }
 
-func (p *Pool) getClient() *Client {
-	c := p.clients[p.nextClient]
-	if p.nextClient+1 < p.poolSize {
-		p.nextClient++
-	} else {
-		p.nextClient = 0
+// send starts the call of the message and sends it to its connection, a
+// failure to do so is sent to the callback channel.
+func (p *Pool) send(ctx context.Context, m *ClientControlMessage) {
+	p.startCall(ctx, m)
+	m.retry = p.retry
+
+	c, err := p.admit(ctx, m)
+	if err != nil {
+		m.report(outcomeNone)
+		m.finish(err)
+		go m.deliver(&ClientResponse{Cmd: m.Cmd, Err: err}, p.clock, p.requestTimeout())
+		return
+	}
+
+	if err := p.post(c, m); err != nil {
+		atomic.AddInt64(&c.handler.inFlight, -1)
+		m.report(outcomeNone)
+		m.finish(err)
+		if err == ErrClosed {
+			go m.deliver(&ClientResponse{Cmd: m.Cmd, Err: err}, p.clock, p.requestTimeout())
+		}
+	}
+}
+
+// post hands the message over to the connection. It fails with ErrAbandoned
+// if the caller stops waiting and with ErrClosed if the pool or the
+// connection is closed first.
+func (p *Pool) post(c *Client, m *ClientControlMessage) (err error) {
+	defer func() {
+		// The connection closes its channel when it quits.
+		if recover() != nil {
+			err = ErrClosed
+		}
+	}()
+
+	select {
+	case c.controlCh <- m:
+		return nil
+	case <-m.abandonedCh():
+		return ErrAbandoned
+	case <-p.done:
+		return ErrClosed
 	}
-	return c
 }
 
+// admit checks the circuit breaker, waits for the rate limiter until ctx is
+// done or MT5RequestTimeout expires, and then chooses the connection for the
+// message.
+func (p *Pool) admit(ctx context.Context, m *ClientControlMessage) (*Client, error) {
+	class := ClassOf(m.Cmd.Name)
+	if b, ok := p.breakers[class]; ok {
+		if err := b.allow(); err != nil {
+			return nil, err
+		}
+		m.breaker = b
+		timeout := p.requestTimeout()
+		if m.Cmd.Name == MT5CommandDealerSend {
+			timeout *= 2
+		}
+		m.watchTimeout(p.clock, timeout)
+	}
+
+	if p.limiter != nil {
+		deadline := p.clock.Now().Add(p.requestTimeout())
+		release, err := p.limiter.wait(ctx, class, deadline)
+		if err != nil {
+			return nil, err
+		}
+		m.release = release
+	}
+	return p.acquire(m.Cmd, m.tried)
+}
+
+// finish reports the outcome of the message, ends its span and releases its
+// rate limiter slots, once it has been handled or failed before reaching a
+// connection.
+func (m *ClientControlMessage) finish(err error) {
+	if err == nil {
+		err = m.err
+	}
+	if m.handled != nil {
+		close(m.handled)
+		m.handled = nil
+	}
+	m.report(outcomeOf(err))
+
+	if m.release != nil {
+		m.release()
+		m.release = nil
+	}
+	if m.span == nil {
+		return
+	}
+	if err != nil {
+		m.span.RecordError(err)
+	}
+	m.span.End()
+}
+
+// request sends cmd to the next connection and waits for the response until
+// MT5RequestTimeout expires or ctx is done.
+func (p *Pool) request(ctx context.Context, cmd *MT5Command) (*ClientResponse, error) {
+	resp, err := p.callContext(ctx, &ClientControlMessage{Cmd: cmd}, p.requestTimeout())
+	if err != nil {
+		return nil, err
+	}
+	return resp, resp.Err
+}
+
+// Close closes the connections of the pool. It may be called more than once,
+// on the pool or on any of its WithContext copies.
 func (p *Pool) Close() {
-	for _, c := range p.clients {
+	p.closeOnce.Do(p.close)
+}
+
+func (p *Pool) close() {
+	p.cancelDial()
+
+	p.clients.mux.Lock()
+	clients := p.clients.list
+	p.clients.list = nil
+	p.clients.closed = true
+	p.clients.mux.Unlock()
+
+	for _, c := range clients {
 		c.handler.Quit()
 	}
+	p.unobserve()
+	close(p.done)
 }
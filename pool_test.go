@@ -0,0 +1,76 @@
+package mt5client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestConfigValidate(t *testing.T) {
+	valid := Config{
+		MT5Host:           "127.0.0.1",
+		MT5Port:           443,
+		MT5Login:          "1000",
+		MT5Password:       "password",
+		MT5APIVersion:     "1",
+		MT5PingTimeout:    30,
+		MT5RequestTimeout: 2,
+	}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("valid config rejected: %v", err)
+	}
+	tests := []struct {
+		field  string
+		modify func(c *Config)
+	}{
+		{"MT5Host", func(c *Config) { c.MT5Host = "" }},
+		{"MT5Port", func(c *Config) { c.MT5Port = 70000 }},
+		{"MT5Password", func(c *Config) { c.MT5Password = "" }},
+		{"MT5RequestTimeout", func(c *Config) { c.MT5RequestTimeout = 0 }},
+		{"MT5PoolMinSize", func(c *Config) { c.MT5PoolSize, c.MT5PoolMinSize = 2, 3 }},
+	}
+	for _, tt := range tests {
+		cfg := valid
+		tt.modify(&cfg)
+		var configErr *ConfigError
+		if err := cfg.Validate(); !errors.As(err, &configErr) || configErr.Field != tt.field {
+			t.Errorf("%s: Validate returned %v", tt.field, err)
+		}
+		if _, err := New(cfg); err == nil {
+			t.Errorf("%s: New accepted an invalid config", tt.field)
+		}
+	}
+}
+
+type countingDialer struct {
+	net.Dialer
+	dials int
+}
+
+func (d *countingDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	d.dials++
+	return d.Dialer.DialContext(ctx, network, address)
+}
+
+func TestPoolCloseWithContext(t *testing.T) {
+	s := newFakeServer(t, func(cmd *MT5Command) string {
+		return cmd.Name + "|RETCODE=0 Done|\r\n"
+	})
+	dialer := &countingDialer{}
+	pool, err := New(s.config(), WithDialer(dialer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dialer.dials != 1 {
+		t.Errorf("%d connections dialed, want 1 through the custom dialer", dialer.dials)
+	}
+
+	cp := pool.WithContext(context.Background())
+	cp.Close()
+	pool.Close()
+	cp.Close()
+	if _, err := pool.Do(context.Background(), MT5CommandCommonGet, nil, nil); err == nil {
+		t.Error("call on a closed pool succeeded")
+	}
+}
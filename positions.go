@@ -1,16 +1,15 @@
 package mt5client
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"strconv"
 	"strings"
-	"time"
 )
 
 func (p *Pool) GetPositionsTotal(login string) {
-	p.getClient().controlCh <- &ClientControlMessage{
+	p.dispatch(&ClientControlMessage{
 		Cmd: &MT5Command{
 			Name: MT5CommandPositionGetTotal,
 			Params: map[string]string{
@@ -18,11 +17,11 @@ func (p *Pool) GetPositionsTotal(login string) {
 			},
 		},
 		Cb: p.cb,
-	}
+	})
 }
 
 func (p *Pool) GetPositionsPage(login string, offset, total int) {
-	p.getClient().controlCh <- &ClientControlMessage{
+	p.dispatch(&ClientControlMessage{
 		Cmd: &MT5Command{
 			Name: MT5CommandPositionGetPage,
 			Params: map[string]string{
@@ -32,11 +31,11 @@ func (p *Pool) GetPositionsPage(login string, offset, total int) {
 			},
 		},
 		Cb: p.cb,
-	}
+	})
 }
 
 func (p *Pool) GetPositionsBatch(login, group, ticket []string, symbol string) {
-	p.getClient().controlCh <- &ClientControlMessage{
+	p.dispatch(&ClientControlMessage{
 		Cmd: &MT5Command{
 			Name: MT5CommandPositionGetBatch,
 			Params: map[string]string{
@@ -47,42 +46,43 @@ func (p *Pool) GetPositionsBatch(login, group, ticket []string, symbol string) {
 			},
 		},
 		Cb: p.cb,
-	}
+	})
 }
 
-func (p *Pool) DeletePositions(positions []uint64) error {
-	cb := make(chan *ClientResponse)
-	defer close(cb)
-
-	tickets := make([]string, 0, len(positions))
-	for _, d := range positions {
-		tickets = append(tickets, fmt.Sprintf("%d", d))
-	}
-
-	p.getClient().controlCh <- &ClientControlMessage{
+// StreamPositionsBatch works like GetPositionsBatch but calls fn for every
+// position as soon as it is decoded from the response.
+func (p *Pool) StreamPositionsBatch(ctx context.Context, login, group, ticket []string, symbol string, fn func(p *Position) error) error {
+	resp, err := p.callContext(ctx, &ClientControlMessage{
 		Cmd: &MT5Command{
-			Name: MT5CommandPositionDelete,
+			Name: MT5CommandPositionGetBatch,
 			Params: map[string]string{
-				"TICKET": strings.Join(tickets, ","),
+				"LOGIN":  strings.Join(login, ","),
+				"GROUP":  strings.Join(group, ","),
+				"TICKET": strings.Join(ticket, ","),
+				"SYMBOL": symbol,
 			},
 		},
-		Cb: cb,
+		Stream: func(item interface{}) error {
+			return fn(item.(*Position))
+		},
+	}, p.requestTimeout())
+	if err != nil {
+		return err
 	}
+	return resp.Err
+}
 
-	select {
-	case resp := <-cb:
-		return resp.Err
-	case <-time.After(time.Duration(p.cfg.MT5RequestTimeout) * time.Second):
-		break
+// DeletePositions is BulkDeletePositions without the report, see
+// DeleteDeals.
+func (p *Pool) DeletePositions(positions []uint64) error {
+	report, err := p.BulkDeletePositions(p.context(), positions)
+	if err != nil {
+		return err
 	}
-
-	return errors.New("timeout expired")
+	return report.notFound()
 }
 
 func (p *Pool) ClosePosition(position *Position) (*DealerUpdates, error) {
-	cb := make(chan *ClientResponse)
-	defer close(cb)
-
 	var pType int
 	if position.Action == DealActionBuy {
 		pType = DealActionSell
@@ -111,121 +111,34 @@ func (p *Pool) ClosePosition(position *Position) (*DealerUpdates, error) {
 	}
 	payload, _ := json.Marshal(params)
 
-	p.getClient().controlCh <- &ClientControlMessage{
+	resp, err := p.call(&ClientControlMessage{
 		Cmd: &MT5Command{
 			Name:    MT5CommandDealerSend,
 			Payload: string(payload),
 		},
-		Cb: cb,
-	}
-
-	select {
-	case resp := <-cb:
-		if resp.Err != nil {
-			return nil, resp.Err
-		}
-		return resp.Response.(*DealerUpdates), nil
-	case <-time.After(time.Duration(p.cfg.MT5RequestTimeout) * time.Second * 2):
-		break
-	}
-
-	return nil, errors.New("timeout expired")
-}
-
-func (c *MT5Client) getPositionsTotal(m *ClientControlMessage) {
-	req, body, err := c.makeRequest(m.Cmd)
+	}, 2*p.requestTimeout())
 	if err != nil {
-		m.Cb <- &ClientResponse{Cmd: m.Cmd, Err: err}
-		return
+		return nil, err
 	}
-
-	c.log.Debugf("#%d %s header: %s body: %s", c.clientId, m.Cmd.Name, req[:MT5HeaderLength], body)
-
-	cmd, err := c.sendRequest(req)
-	if err != nil {
-		m.Cb <- &ClientResponse{Cmd: m.Cmd, Err: err}
-		return
-	}
-
-	if cmd.Params[MT5RetCode] != MT5RetCodeSuccess {
-		m.Cb <- &ClientResponse{Cmd: m.Cmd, Err: fmt.Errorf("%s retcode error: %s", m.Cmd.Name, cmd.Params[MT5RetCode])}
-		return
-	}
-
-	c.log.Debugf("%s response: %+v", cmd.Name, cmd.Params)
-
-	total, err := strconv.Atoi(cmd.Params["TOTAL"])
-	m.Cb <- &ClientResponse{
-		Cmd:      m.Cmd,
-		Response: &PositionsTotalResponse{Total: total},
-		Err:      err,
+	if resp.Err != nil {
+		return nil, resp.Err
 	}
+	return resp.Response.(*DealerUpdates), nil
 }
 
-func (c *MT5Client) getPositions(m *ClientControlMessage) {
-	req, body, err := c.makeRequest(m.Cmd)
-	if err != nil {
-		m.Cb <- &ClientResponse{Cmd: m.Cmd, Err: err}
-		return
-	}
-
-	c.log.Debugf("#%d %s header: %s body: %s", c.clientId, m.Cmd.Name, req[:MT5HeaderLength], body)
-
-	cmd, err := c.sendRequest(req)
-	if err != nil {
-		m.Cb <- &ClientResponse{Cmd: m.Cmd, Err: err}
-		return
-	}
-
-	if cmd.Params[MT5RetCode] != MT5RetCodeSuccess {
-		m.Cb <- &ClientResponse{Cmd: m.Cmd, Err: fmt.Errorf("%s retcode error: %s", m.Cmd.Name, cmd.Params[MT5RetCode])}
-		return
-	}
-
-	c.log.Debugf("#%d %s response: %+v", c.clientId, cmd.Name, cmd.Params)
+func decodePositionsTotal(x *Exchange) (interface{}, error) {
+	total, err := strconv.Atoi(x.Response.Params["TOTAL"])
+	return &PositionsTotalResponse{Total: total}, err
+}
 
+func decodePositions(x *Exchange) (interface{}, error) {
 	positions := make([]Position, 0, 100)
-	if err = json.Unmarshal([]byte(cmd.Payload), &positions); err != nil {
-		m.Cb <- &ClientResponse{Cmd: m.Cmd, Err: fmt.Errorf("%s response unmarshal error: %v", m.Cmd.Name, err)}
-		return
-	}
-
-	if len(positions) > 0 {
-		c.log.Debugf("#%d Positions received: %d", c.clientId, len(positions))
-	}
-
-	m.Cb <- &ClientResponse{
-		Cmd:      m.Cmd,
-		Response: &PositionsResponse{Positions: positions},
-		Err:      err,
+	if err := x.Unmarshal(&positions); err != nil {
+		return nil, err
 	}
+	return &PositionsResponse{Positions: positions}, nil
 }
 
-func (c *MT5Client) deletePositions(m *ClientControlMessage) {
-	req, body, err := c.makeRequest(m.Cmd)
-	if err != nil {
-		safeSend(m.Cb, &ClientResponse{Cmd: m.Cmd, Err: err})
-		return
-	}
-
-	c.log.Debugf("#%d %s header: %s body: %s", c.clientId, m.Cmd.Name, req[:MT5HeaderLength], body)
-
-	cmd, err := c.sendRequest(req)
-	if err != nil {
-		safeSend(m.Cb, &ClientResponse{Cmd: m.Cmd, Err: err})
-		return
-	}
-
-	if cmd.Params[MT5RetCode] != MT5RetCodeSuccess {
-		safeSend(m.Cb, &ClientResponse{Cmd: m.Cmd, Err: fmt.Errorf("%s retcode error: %s (%s)", m.Cmd.Name, cmd.Params[MT5RetCode], req)})
-		return
-	}
-
-	c.log.Debugf("%s response: %+v", cmd.Name, cmd.Params)
-
-	safeSend(m.Cb, &ClientResponse{
-		Cmd:      m.Cmd,
-		Response: cmd,
-		Err:      err,
-	})
+func newPosition() interface{} {
+	return &Position{}
 }
@@ -0,0 +1,311 @@
+package mt5client
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var _ Metrics = (*PrometheusMetrics)(nil)
+
+// DefaultLatencyBuckets are the upper bounds, in seconds, of the command
+// latency histogram.
+var DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// PrometheusMetrics collects the measurements and exposes them in the
+// Prometheus text format without depending on the Prometheus client library.
+// It is an http.Handler to be mounted on the metrics endpoint:
+//
+//	metrics := mt5client.NewPrometheusMetrics("mt5")
+//	http.Handle("/metrics", metrics)
+//	pool, err := mt5client.New(cfg, mt5client.WithMetrics(metrics))
+type PrometheusMetrics struct {
+	namespace string
+	buckets   []float64
+
+	mux      sync.Mutex
+	commands map[commandKey]*commandMetrics
+	waits    map[CommandClass]*histogram
+	rejected map[CommandClass]uint64
+	pools    map[uint64]func() PoolStats
+	poolSeq  uint64
+}
+
+type commandKey struct {
+	command string
+	result  string
+}
+
+type commandMetrics struct {
+	count         uint64
+	bytesSent     uint64
+	bytesReceived uint64
+	chunks        uint64
+	latency       histogram
+}
+
+type histogram struct {
+	// buckets are not cumulative, they are summed up when written.
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(bounds []float64) histogram {
+	return histogram{buckets: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(bounds []float64, v float64) {
+	h.buckets[sort.SearchFloat64s(bounds, v)]++
+	h.sum += v
+	h.count++
+}
+
+func (h histogram) copy() histogram {
+	h.buckets = append([]uint64(nil), h.buckets...)
+	return h
+}
+
+// NewPrometheusMetrics creates the metrics with names prefixed by namespace,
+// e.g. "mt5_commands_total".
+func NewPrometheusMetrics(namespace string) *PrometheusMetrics {
+	return &PrometheusMetrics{
+		namespace: namespace,
+		buckets:   DefaultLatencyBuckets,
+		commands:  make(map[commandKey]*commandMetrics),
+		waits:     make(map[CommandClass]*histogram),
+		rejected:  make(map[CommandClass]uint64),
+		pools:     make(map[uint64]func() PoolStats),
+	}
+}
+
+func (m *PrometheusMetrics) ObserveCommand(o *CommandObservation) {
+	key := commandKey{command: o.Command, result: o.RetcodeClass()}
+	latency := o.Latency.Seconds()
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	c, ok := m.commands[key]
+	if !ok {
+		c = &commandMetrics{latency: newHistogram(m.buckets)}
+		m.commands[key] = c
+	}
+	c.count++
+	c.bytesSent += uint64(o.BytesSent)
+	c.bytesReceived += uint64(o.BytesReceived)
+	c.chunks += uint64(o.Chunks)
+	c.latency.observe(m.buckets, latency)
+}
+
+func (m *PrometheusMetrics) ObserveLimiterWait(class CommandClass, wait time.Duration, err error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	h, ok := m.waits[class]
+	if !ok {
+		hist := newHistogram(m.buckets)
+		h = &hist
+		m.waits[class] = h
+	}
+	h.observe(m.buckets, wait.Seconds())
+	if err != nil {
+		m.rejected[class]++
+	}
+}
+
+func (m *PrometheusMetrics) ObservePool(stats func() PoolStats) func() {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.poolSeq++
+	id := m.poolSeq
+	m.pools[id] = stats
+	return func() {
+		m.mux.Lock()
+		defer m.mux.Unlock()
+		delete(m.pools, id)
+	}
+}
+
+// Count returns the number of commands observed with the retcode class, e.g.
+// Count(MT5CommandDealGetBatch, RetcodeClassOK).
+func (m *PrometheusMetrics) Count(command, retcodeClass string) uint64 {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if c, ok := m.commands[commandKey{command: command, result: retcodeClass}]; ok {
+		return c.count
+	}
+	return 0
+}
+
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = m.WriteTo(w)
+}
+
+// WriteTo writes all metrics in the Prometheus text exposition format.
+func (m *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	m.mux.Lock()
+	keys := make([]commandKey, 0, len(m.commands))
+	commands := make(map[commandKey]commandMetrics, len(m.commands))
+	for k, c := range m.commands {
+		keys = append(keys, k)
+		copied := *c
+		copied.latency = c.latency.copy()
+		commands[k] = copied
+	}
+	waits := make(map[CommandClass]histogram, len(m.waits))
+	for class, h := range m.waits {
+		waits[class] = h.copy()
+	}
+	rejected := make(map[CommandClass]uint64, len(m.rejected))
+	for class, n := range m.rejected {
+		rejected[class] = n
+	}
+	pools := make([]func() PoolStats, 0, len(m.pools))
+	for _, stats := range m.pools {
+		pools = append(pools, stats)
+	}
+	m.mux.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].command != keys[j].command {
+			return keys[i].command < keys[j].command
+		}
+		return keys[i].result < keys[j].result
+	})
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+
+	name := m.name("commands_total")
+	m.header(cw, name, "counter", "MT5 commands by command, class and retcode class.")
+	for _, k := range keys {
+		fmt.Fprintf(cw, "%s{command=%q,class=%q,result=%q} %d\n", name, k.command, ClassOf(k.command), k.result, commands[k].count)
+	}
+
+	name = m.name("command_duration_seconds")
+	m.header(cw, name, "histogram", "Latency of MT5 commands.")
+	for _, k := range keys {
+		m.writeHistogram(cw, name, fmt.Sprintf("command=%q,result=%q", k.command, k.result), commands[k].latency)
+	}
+
+	counters := []struct {
+		name  string
+		help  string
+		value func(c *commandMetrics) uint64
+	}{
+		{"command_sent_bytes_total", "Bytes sent for MT5 commands.", func(c *commandMetrics) uint64 { return c.bytesSent }},
+		{"command_received_bytes_total", "Bytes received for MT5 commands.", func(c *commandMetrics) uint64 { return c.bytesReceived }},
+		{"command_chunks_total", "Packets MT5 responses were split into.", func(c *commandMetrics) uint64 { return c.chunks }},
+	}
+	for _, counter := range counters {
+		name = m.name(counter.name)
+		m.header(cw, name, "counter", counter.help)
+		for _, k := range keys {
+			c := commands[k]
+			fmt.Fprintf(cw, "%s{command=%q,result=%q} %d\n", name, k.command, k.result, counter.value(&c))
+		}
+	}
+
+	connections := make(map[ConnState]int)
+	queueDepth, outboxDepth := 0, 0
+	var outboxDropped uint64
+	for _, stats := range pools {
+		s := stats()
+		for state, n := range s.Connections {
+			connections[state] += n
+		}
+		queueDepth += s.QueueDepth
+		outboxDepth += s.OutboxDepth
+		outboxDropped += s.OutboxDropped
+	}
+
+	name = m.name("connections")
+	m.header(cw, name, "gauge", "MT5 pool connections by state.")
+	for state := StateConnecting; state <= StateClosed; state++ {
+		fmt.Fprintf(cw, "%s{state=%q} %d\n", name, state, connections[state])
+	}
+
+	name = m.name("queue_depth")
+	m.header(cw, name, "gauge", "MT5 commands dispatched and not handled yet.")
+	fmt.Fprintf(cw, "%s %d\n", name, queueDepth)
+
+	name = m.name("outbox_depth")
+	m.header(cw, name, "gauge", "MT5 responses of asynchronous calls not read yet.")
+	fmt.Fprintf(cw, "%s %d\n", name, outboxDepth)
+
+	name = m.name("outbox_dropped_total")
+	m.header(cw, name, "counter", "MT5 responses of asynchronous calls dropped because the outbox was full.")
+	fmt.Fprintf(cw, "%s %d\n", name, outboxDropped)
+
+	classes := make([]CommandClass, 0, len(waits))
+	for class := range waits {
+		classes = append(classes, class)
+	}
+	sort.Slice(classes, func(i, j int) bool { return classes[i] < classes[j] })
+
+	name = m.name("limiter_wait_seconds")
+	m.header(cw, name, "histogram", "Time MT5 commands waited for the rate limiter.")
+	for _, class := range classes {
+		m.writeHistogram(cw, name, fmt.Sprintf("class=%q", class), waits[class])
+	}
+
+	name = m.name("limiter_rejected_total")
+	m.header(cw, name, "counter", "MT5 commands rejected by the rate limiter.")
+	for _, class := range classes {
+		fmt.Fprintf(cw, "%s{class=%q} %d\n", name, class, rejected[class])
+	}
+
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.n, cw.err
+}
+
+func (m *PrometheusMetrics) writeHistogram(w io.Writer, name, labels string, h histogram) {
+	var cumulative uint64
+	for i, le := range m.buckets {
+		cumulative += h.buckets[i]
+		fmt.Fprintf(w, "%s_bucket{%s,le=%q} %d\n", name, labels, formatFloat(le), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+	fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count)
+}
+
+func (m *PrometheusMetrics) name(name string) string {
+	if m.namespace == "" {
+		return name
+	}
+	return m.namespace + "_" + name
+}
+
+func (m *PrometheusMetrics) header(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// countingWriter keeps the number of bytes written and the first error.
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	w.err = err
+	return n, err
+}
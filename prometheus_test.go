@@ -0,0 +1,92 @@
+package mt5client
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPrometheusMetricsWriteTo(t *testing.T) {
+	s := newFakeServer(t, func(cmd *MT5Command) string {
+		if cmd.Name == MT5CommandCommonGet {
+			return "COMMON_GET|RETCODE=0 Done|\r\n{\"Name\":\"Test\"}"
+		}
+		return cmd.Name + "|RETCODE=3 Invalid parameters|\r\n"
+	})
+	metrics := NewPrometheusMetrics("mt5")
+	pool, err := New(s.config(), WithMetrics(metrics))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := pool.GetCommon(); err != nil {
+		t.Fatal(err)
+	}
+	var retcodeErr *RetcodeError
+	if _, err := pool.Do(context.Background(), "TEST_UNKNOWN", nil, nil); !errors.As(err, &retcodeErr) {
+		t.Fatalf("Do returned %v, want a *RetcodeError", err)
+	}
+
+	out := writeMetrics(t, metrics)
+	for _, line := range []string{
+		`mt5_commands_total{command="COMMON_GET",class="read",result="ok"} 1`,
+		`mt5_commands_total{command="TEST_UNKNOWN",class="other",result="error"} 1`,
+		`mt5_command_duration_seconds_count{command="COMMON_GET",result="ok"} 1`,
+		`mt5_command_duration_seconds_bucket{command="COMMON_GET",result="ok",le="+Inf"} 1`,
+		`mt5_command_chunks_total{command="COMMON_GET",result="ok"} 1`,
+		`mt5_connections{state="connected"} 1`,
+		`mt5_queue_depth 0`,
+		`# TYPE mt5_command_duration_seconds histogram`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, out)
+		}
+	}
+	for _, name := range []string{"mt5_command_sent_bytes_total", "mt5_command_received_bytes_total"} {
+		if n := metricValue(t, out, name+`{command="COMMON_GET",result="ok"}`); n <= 0 {
+			t.Errorf("%s is %d, want more than 0", name, n)
+		}
+	}
+	if got := metrics.Count(MT5CommandCommonGet, RetcodeClassOK); got != 1 {
+		t.Errorf("Count is %d, want 1", got)
+	}
+
+	pool.Close()
+	if n := len(metrics.pools); n != 0 {
+		t.Errorf("%d pools observed after Close, want 0", n)
+	}
+	out = writeMetrics(t, metrics)
+	if line := `mt5_connections{state="connected"} 0`; !strings.Contains(out, line+"\n") {
+		t.Errorf("closed pool still counted, missing %q in:\n%s", line, out)
+	}
+}
+
+func writeMetrics(t *testing.T, m *PrometheusMetrics) string {
+	t.Helper()
+	var b strings.Builder
+	n, err := m.WriteTo(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(b.Len()) {
+		t.Errorf("WriteTo returned %d, wrote %d bytes", n, b.Len())
+	}
+	return b.String()
+}
+
+func metricValue(t *testing.T, out, series string) int64 {
+	t.Helper()
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, series+" ") {
+			n, err := strconv.ParseInt(strings.TrimPrefix(line, series+" "), 10, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return n
+		}
+	}
+	t.Fatalf("missing %s", series)
+	return 0
+}
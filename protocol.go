@@ -1,13 +1,15 @@
 package mt5client
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"golang.org/x/text/encoding/unicode"
 	"math/rand"
+	"net"
+	"sort"
 	"strconv"
 	"strings"
-	"syscall"
 	"time"
 )
 
@@ -31,6 +33,7 @@ const (
 	MT5CommandDealGetPage          = "DEAL_GET_PAGE"
 	MT5CommandDealGetBatch         = "DEAL_GET_BATCH"
 	MT5CommandDealDelete           = "DEAL_DELETE"
+	MT5CommandDealAdd              = "DEAL_ADD"
 	MT5CommandPositionGetTotal     = "POSITION_GET_TOTAL"
 	MT5CommandPositionGetPage      = "POSITION_GET_PAGE"
 	MT5CommandPositionGetBatch     = "POSITION_GET_BATCH"
@@ -43,10 +46,16 @@ const (
 	MT5CommandUserDelete           = "USER_DELETE"
 	MT5CommandUserAccountGetBatch  = "USER_ACCOUNT_GET_BATCH"
 	MT5CommandTradeBalance         = "TRADE_BALANCE"
+	MT5CommandTradeCalcMargin      = "TRADE_CALC_MARGIN"
 	MT5CommandTickGetHistory       = "TICK_HISTORY_GET"
 	MT5CommandChartGet             = "CHART_GET"
 	MT5CommandDealerSend           = "DEALER_SEND"
 	MT5CommandDealerUpdates        = "DEALER_UPDATES"
+	MT5CommandMailSend             = "MAIL_SEND"
+	MT5CommandNewsSend             = "NEWS_SEND"
+	MT5CommandCommonGet            = "COMMON_GET"
+	MT5CommandTimeGet              = "TIME_GET"
+	MT5CommandTimeServer           = "TIME_SERVER"
 )
 
 type MT5Header struct {
@@ -63,19 +72,98 @@ type MT5Command struct {
 
 var (
 	utf16 = unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
+
+	paramEscaper = strings.NewReplacer(`\`, `\\`, MT5ParamSeparator, `\`+MT5ParamSeparator, MT5CommandSeparator, `\`+MT5CommandSeparator)
 )
 
 func (c *MT5Client) makeRequest(cmd *MT5Command) ([]byte, string, error) {
-	body := cmd.Name + MT5CommandSeparator
+	if err := validateCommand(cmd); err != nil {
+		return nil, "", err
+	}
+	body := encodeCommand(cmd)
+	req, err := makePacket(body, 0, 0)
+	return req, body, err
+}
+
+// validateCommand rejects line breaks in the name and the parameters: the
+// command line ends at the first MT5PacketSeparator and the protocol has no
+// escape for them.
+func validateCommand(cmd *MT5Command) error {
+	if strings.ContainsAny(cmd.Name, "\r\n") {
+		return fmt.Errorf("%q command name contains a line break", cmd.Name)
+	}
+	for k, v := range cmd.Params {
+		if strings.ContainsAny(k, "\r\n") || strings.ContainsAny(v, "\r\n") {
+			return fmt.Errorf("%s param %q contains a line break", cmd.Name, k)
+		}
+	}
+	return nil
+}
+
+// encodeCommand builds the request body. Parameters are written in key order
+// so the same command always produces the same body. The command must pass
+// validateCommand.
+func encodeCommand(cmd *MT5Command) string {
+	keys := make([]string, 0, len(cmd.Params))
 	for k, v := range cmd.Params {
 		if v != "" {
-			body += k + MT5ParamSeparator + v + MT5CommandSeparator
+			keys = append(keys, k)
 		}
 	}
-	body += MT5PacketSeparator
-	body += cmd.Payload
-	req, err := makePacket(body, 0, 0)
-	return req, body, err
+	sort.Strings(keys)
+
+	var body strings.Builder
+	body.WriteString(escapeParam(cmd.Name))
+	body.WriteString(MT5CommandSeparator)
+	for _, k := range keys {
+		body.WriteString(escapeParam(k))
+		body.WriteString(MT5ParamSeparator)
+		body.WriteString(escapeParam(cmd.Params[k]))
+		body.WriteString(MT5CommandSeparator)
+	}
+	body.WriteString(MT5PacketSeparator)
+	body.WriteString(cmd.Payload)
+	return body.String()
+}
+
+// escapeParam escapes the protocol separators and the escape character itself
+// so that the value can be safely placed between '=' and '|'.
+func escapeParam(v string) string {
+	return paramEscaper.Replace(v)
+}
+
+// unescapeParam is the inverse of escapeParam: a backslash makes the next
+// character literal.
+func unescapeParam(v string) string {
+	if !strings.Contains(v, `\`) {
+		return v
+	}
+	var b strings.Builder
+	b.Grow(len(v))
+	for i := 0; i < len(v); i++ {
+		if v[i] == '\\' && i+1 < len(v) {
+			i++
+		}
+		b.WriteByte(v[i])
+	}
+	return b.String()
+}
+
+// splitEscaped splits s around every sep that is not preceded by the escape
+// character. The parts are returned still escaped.
+func splitEscaped(s string, sep byte) []string {
+	parts := make([]string, 0, 8)
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
 }
 
 func makePacket(body string, packetNumber uint16, flag uint8) ([]byte, error) {
@@ -92,81 +180,121 @@ func makePacket(body string, packetNumber uint16, flag uint8) ([]byte, error) {
 	return p, nil
 }
 
-func (c *MT5Client) reconnect() error {
-	c.connMux.Lock()
-	defer c.connMux.Unlock()
+// sendRequest sends the request over the current connection and reads the
+// whole response. A transport failure hands the connection over to the
+// reconnect supervisor.
+func (c *MT5Client) sendRequest(ctx context.Context, request []byte) (*MT5Command, error) {
+	ctx, span := c.tracer.Start(ctx, "mt5client.sendRequest")
+	defer span.End()
+	span.SetAttribute(AttrConn, c.clientId)
 
-	_ = c.conn.Close()
-	c.conn = nil
+	conn, err := c.activeConn()
+	if err != nil {
+		span.RecordError(err)
+		return nil, &TransportError{Err: err}
+	}
 
-	for {
-		if err := c.init(); err != nil {
-			c.log.Errorf("Reconnect error %v", err)
-			time.Sleep(time.Second)
-		} else {
-			c.log.Infof("Reconnect successfully")
-			break
-		}
+	stats := wireStatsFrom(ctx)
+	stats.start = c.clock.Now()
+	body, err := c.roundTrip(ctx, conn, request, stats)
+	c.recordResult(err)
+	if err != nil {
+		span.RecordError(err)
+		c.log.Errorf("#%d request failed, trying reconnect %v", c.clientId, err)
+		c.startReconnect()
+		return nil, &TransportError{Err: err, Sent: true}
+	}
+
+	cmd, err := parseBody(body)
+	if err != nil {
+		span.RecordError(err)
+		return nil, err
 	}
+	traceResult(span, cmd, stats)
+	c.logResult(cmd, c.clock.Now().Sub(stats.start))
 
-	return c.auth()
+	return cmd, nil
 }
 
-func (c *MT5Client) sendRequest(request []byte) (*MT5Command, error) {
-	if c.conn == nil {
-		return nil, fmt.Errorf("no connection")
-	}
-	_, err := c.conn.Write(request)
-	if err != nil {
-		if errors.Is(err, syscall.EPIPE) {
-			c.log.Errorf("#%d write failed, trying reconnect %v", c.clientId, err)
-			_ = c.reconnect()
-			return nil, err
-		}
+// logResult logs the retcode and latency of a command as structured fields.
+func (c *MT5Client) logResult(cmd *MT5Command, latency time.Duration) {
+	c.log.With(Fields{
+		FieldCommand: cmd.Name,
+		FieldRetcode: cmd.Params[MT5RetCode],
+		FieldLatency: latency,
+	}).Debugf("#%d %s completed", c.clientId, cmd.Name)
+}
+
+// roundTrip writes the request to conn and reads the response body, joining
+// all of its chunks. The traffic is counted in stats.
+func (c *MT5Client) roundTrip(ctx context.Context, conn net.Conn, request []byte, stats *wireStats) ([]byte, error) {
+	if err := c.write(conn, request); err != nil {
 		return nil, fmt.Errorf("#%d write request failed %v", c.clientId, err)
 	}
+	stats.sent += len(request)
 
 	buffer := make([]byte, 0)
-	body := make([]byte, 0)
 
 	for {
-		header, err := c.readHeader()
+		header, chunk, err := c.readChunk(ctx, conn, buffer, stats)
 		if err != nil {
-			c.log.Errorf("#%d read header failed %v", c.clientId, err)
-			_ = c.reconnect()
 			return nil, err
 		}
-
-		//c.log.Debugf("Response header %+v", header)
+		buffer = chunk
 
 		if header.bodyLen == 0 {
 			c.log.Debugf("#%d PING packet. Header: %+v", c.clientId, header)
 			continue
 		}
 
-		if header.flag == 0x01 {
-			chunk, err := c.readBody(header.bodyLen, []byte{})
-			if err != nil {
-				return nil, err
-			}
-			buffer = append(buffer, chunk...)
-		} else {
-			body, err = c.readBody(header.bodyLen, buffer)
-			if err != nil {
-				return nil, err
-			}
-			break
+		if header.flag != 0x01 {
+			return buffer, nil
 		}
 	}
+}
+
+// readChunk reads the next packet and appends its body to buffer.
+func (c *MT5Client) readChunk(ctx context.Context, conn net.Conn, buffer []byte, stats *wireStats) (*MT5Header, []byte, error) {
+	_, span := c.tracer.Start(ctx, "mt5client.readChunk")
+	defer span.End()
+
+	header, err := c.readHeader(conn)
+	if err != nil {
+		span.RecordError(err)
+		return nil, nil, err
+	}
+	stats.received += MT5HeaderLength
+
+	if header.bodyLen == 0 {
+		return header, buffer, nil
+	}
+
+	buffer, err = c.readBody(conn, header.bodyLen, buffer)
+	if err != nil {
+		span.RecordError(err)
+		return nil, nil, err
+	}
+	stats.received += header.bodyLen
+	stats.chunks++
+	span.SetAttribute(AttrPayloadSize, header.bodyLen)
 
-	return parseBody(body)
+	return header, buffer, nil
+}
+
+// write serializes writes to conn, requests and pings come from different
+// goroutines.
+func (c *MT5Client) write(conn net.Conn, p []byte) error {
+	c.connMux.Lock()
+	defer c.connMux.Unlock()
+	_, err := conn.Write(p)
+	return err
 }
 
-func (c *MT5Client) readBody(size int, appendBuffer []byte) ([]byte, error) {
+func (c *MT5Client) readBody(conn net.Conn, size int, appendBuffer []byte) ([]byte, error) {
 	buffer := make([]byte, 0)
 	for len(buffer) < size {
 		packet := make([]byte, size-len(buffer))
-		n, err := c.conn.Read(packet)
+		n, err := conn.Read(packet)
 		if err != nil {
 			return nil, fmt.Errorf("read body failed: %v", err)
 		}
@@ -175,11 +303,11 @@ func (c *MT5Client) readBody(size int, appendBuffer []byte) ([]byte, error) {
 	return append(appendBuffer, buffer...), nil
 }
 
-func (c *MT5Client) readHeader() (*MT5Header, error) {
+func (c *MT5Client) readHeader(conn net.Conn) (*MT5Header, error) {
 	buffer := make([]byte, 0)
 	for len(buffer) < MT5HeaderLength {
 		packet := make([]byte, MT5HeaderLength-len(buffer))
-		n, err := c.conn.Read(packet)
+		n, err := conn.Read(packet)
 		if err != nil {
 			return nil, fmt.Errorf("read header failed: %v", err)
 		}
@@ -213,29 +341,39 @@ func parseBody(b []byte) (*MT5Command, error) {
 		return nil, fmt.Errorf("parse body error: %v", err)
 	}
 
-	cmd := &MT5Command{
-		Params: make(map[string]string, 5),
-	}
-
 	pIdx := strings.Index(string(body), MT5PacketSeparator)
 	if pIdx == -1 {
 		return nil, errors.New("error parsing body")
 	}
-	command := string(body[:pIdx])
+
+	cmd := parseCommand(string(body[:pIdx]))
 	cmd.Payload = string(body[pIdx+len(MT5PacketSeparator):])
 
-	for i, c := range strings.Split(command, MT5CommandSeparator) {
+	return cmd, nil
+}
+
+// parseCommand parses the command line of a response, i.e. the part of the
+// body before the packet separator.
+func parseCommand(command string) *MT5Command {
+	cmd := &MT5Command{
+		Params: make(map[string]string, 5),
+	}
+
+	for i, c := range splitEscaped(command, MT5CommandSeparator[0]) {
 		if i == 0 {
-			cmd.Name = c
+			cmd.Name = unescapeParam(c)
 		} else {
-			p := strings.Split(c, MT5ParamSeparator)
-			if len(p) == 2 {
-				cmd.Params[p[0]] = p[1]
+			p := splitEscaped(c, MT5ParamSeparator[0])
+			if len(p) < 2 {
+				continue
 			}
+			// The value keeps any further separators, they can only be
+			// there unescaped if the server did not escape them.
+			cmd.Params[unescapeParam(p[0])] = unescapeParam(c[len(p[0])+len(MT5ParamSeparator):])
 		}
 	}
 
-	return cmd, nil
+	return cmd
 }
 
 func makeRandomString() []byte {
@@ -0,0 +1,74 @@
+//go:build go1.18
+// +build go1.18
+
+package mt5client
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// FuzzEscapeRoundTrip checks that every valid command survives
+// encodeCommand, makePacket and parseBody unchanged, and that the ones with
+// line breaks are rejected.
+func FuzzEscapeRoundTrip(f *testing.F) {
+	f.Add("USER_UPDATE", "COMMENT", `a|b=c\d`, `{"Login":"1"}`)
+	f.Add("TRADE_BALANCE", `K\|=`, `\`, "")
+	f.Add("MAIL_SEND", "SUBJECT", "line\r\nbreak", "body\r\nwith\r\nlines")
+	f.Add(`NA|ME=`, "", "value", "\r\n")
+
+	f.Fuzz(func(t *testing.T, name, key, value, payload string) {
+		for _, s := range []string{name, key, value, payload} {
+			if !utf8.ValidString(s) {
+				t.Skip()
+			}
+		}
+		cmd := &MT5Command{
+			Name:    name,
+			Params:  map[string]string{key: value, "LOGIN": "1000"},
+			Payload: payload,
+		}
+
+		if err := validateCommand(cmd); err != nil {
+			if !strings.ContainsAny(name+key+value, "\r\n") && !strings.ContainsAny(cmd.Params["LOGIN"], "\r\n") {
+				t.Fatalf("valid command rejected: %v", err)
+			}
+			return
+		}
+		if strings.ContainsAny(name+key+value, "\r\n") {
+			t.Fatalf("line break accepted in %q", name+key+value)
+		}
+
+		packet, err := makePacket(encodeCommand(cmd), 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := parseBody(packet[9:])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got.Name != cmd.Name {
+			t.Errorf("name %q, want %q", got.Name, cmd.Name)
+		}
+		if got.Payload != cmd.Payload {
+			t.Errorf("payload %q, want %q", got.Payload, cmd.Payload)
+		}
+		want := make(map[string]string, len(cmd.Params))
+		for k, v := range cmd.Params {
+			// Empty values are not sent.
+			if v != "" {
+				want[k] = v
+			}
+		}
+		if len(got.Params) != len(want) {
+			t.Errorf("params %q, want %q", got.Params, want)
+		}
+		for k, v := range want {
+			if got.Params[k] != v {
+				t.Errorf("param %q is %q, want %q", k, got.Params[k], v)
+			}
+		}
+	})
+}
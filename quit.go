@@ -6,6 +6,7 @@ func (c *MT5Client) Quit() {
 	defer func() {
 		close(c.controlCh)
 	}()
+	c.closeDone()
 	cb := make(chan *ClientResponse)
 	c.controlCh <- &ClientControlMessage{
 		Cmd: &MT5Command{Name: MT5CommandQuit},
@@ -17,16 +18,25 @@ func (c *MT5Client) Quit() {
 func (c *MT5Client) quit(m *ClientControlMessage) {
 	var err error
 	defer func() {
-		m.Cb <- &ClientResponse{Cmd: m.Cmd, Err: err}
+		c.respond(m, &ClientResponse{Cmd: m.Cmd, Err: err})
 	}()
-	if c.conn != nil {
+	c.closeDone()
+	c.setState(StateClosed)
+
+	c.connMux.Lock()
+	conn := c.conn
+	c.conn = nil
+	c.connMux.Unlock()
+
+	if conn != nil {
 		c.log.Debugf("MT5Client #%d quit", c.clientId)
 		body := fmt.Sprintf("%s%s", MT5CommandQuit, MT5PacketSeparator)
 		request, _ := makePacket(body, 0, 0)
-		_, err = c.conn.Write(request)
+		_, err = conn.Write(request)
 		if err != nil {
+			_ = conn.Close()
 			return
 		}
-		err = c.conn.Close()
+		err = conn.Close()
 	}
 }
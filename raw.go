@@ -0,0 +1,60 @@
+package mt5client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrReservedCommand is returned by Do for the commands managing the
+// connection itself.
+var ErrReservedCommand = errors.New("mt5 command is reserved for the connection")
+
+var reservedCommands = map[string]struct{}{
+	MT5CommandAuthStart:  {},
+	MT5CommandAuthAnswer: {},
+	MT5CommandQuit:       {},
+}
+
+// Do sends any Web API command, including the ones this package does not
+// wrap, and returns the response with its parameters and raw payload. It
+// waits until MT5RequestTimeout expires or ctx is done. Unknown commands are
+// never retried once sent, see RetryPolicy. A retcode other than success is
+// returned as a *RetcodeError. Use DecodeJSON to decode the payload.
+func (p *Pool) Do(ctx context.Context, name string, params map[string]string, payload []byte) (*MT5Command, error) {
+	if name == "" {
+		return nil, errors.New("mt5 command name is empty")
+	}
+	if _, ok := reservedCommands[name]; ok {
+		return nil, fmt.Errorf("%s: %w", name, ErrReservedCommand)
+	}
+
+	resp, err := p.callContext(ctx, &ClientControlMessage{
+		Cmd: &MT5Command{
+			Name:    name,
+			Params:  params,
+			Payload: string(payload),
+		},
+		raw: true,
+	}, p.requestTimeout())
+	if err != nil {
+		return nil, err
+	}
+	if resp.Err != nil {
+		return nil, resp.Err
+	}
+	return resp.Response.(*MT5Command), nil
+}
+
+// DecodeJSON decodes the JSON payload of a response returned by Do into v.
+func DecodeJSON(cmd *MT5Command, v interface{}) error {
+	return unmarshalPayload(cmd.Name, cmd.Payload, v)
+}
+
+func unmarshalPayload(name, payload string, v interface{}) error {
+	if err := json.Unmarshal([]byte(payload), v); err != nil {
+		return fmt.Errorf("%s response unmarshal error: %v", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package mt5client
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestDo(t *testing.T) {
+	s := newFakeServer(t, func(cmd *MT5Command) string {
+		switch cmd.Name {
+		case "FUTURE_GET":
+			return "FUTURE_GET|RETCODE=0 Done|X=" + cmd.Params["X"] + "|\r\n{\"Echo\":" + cmd.Payload + "}"
+		case "DROP_SET":
+			return ""
+		}
+		return cmd.Name + "|RETCODE=13 Not found|\r\n"
+	})
+	cfg := s.config()
+	cfg.MT5PoolSize = 2
+	pool, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	ctx := context.Background()
+
+	resp, err := pool.Do(ctx, "FUTURE_GET", map[string]string{"X": "42"}, []byte(`{"Login":"1000"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Name != "FUTURE_GET" || resp.Params["X"] != "42" {
+		t.Errorf("response %+v, want the parameters of the server", resp)
+	}
+	var v struct{ Echo struct{ Login string } }
+	if err := DecodeJSON(resp, &v); err != nil || v.Echo.Login != "1000" {
+		t.Errorf("decoded %+v: %v, want the payload sent", v, err)
+	}
+	if err := DecodeJSON(&MT5Command{Name: "FUTURE_GET", Payload: "{"}, &v); err == nil {
+		t.Error("malformed payload decoded")
+	}
+
+	var retcodeErr *RetcodeError
+	if _, err := pool.Do(ctx, "MISSING_GET", nil, nil); !errors.As(err, &retcodeErr) || retcodeErr.Retcode != "13 Not found" {
+		t.Errorf("rejected command returned %v, want a *RetcodeError", err)
+	}
+
+	// Unknown commands may have side effects, they are not sent again.
+	if _, err := pool.Do(ctx, "DROP_SET", nil, nil); !errors.Is(err, ErrOutcomeUnknown) {
+		t.Errorf("dropped command returned %v, want ErrOutcomeUnknown", err)
+	}
+	if n := s.count("DROP_SET"); n != 1 {
+		t.Errorf("%d DROP_SET sent, want no retry", n)
+	}
+
+	for _, name := range []string{MT5CommandAuthStart, MT5CommandAuthAnswer, MT5CommandQuit} {
+		if _, err := pool.Do(ctx, name, nil, nil); !errors.Is(err, ErrReservedCommand) {
+			t.Errorf("Do(%s) returned %v, want ErrReservedCommand", name, err)
+		}
+	}
+	if _, err := pool.Do(ctx, "", nil, nil); err == nil {
+		t.Error("command without a name sent")
+	}
+}
@@ -0,0 +1,309 @@
+package mt5client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ConnState int32
+
+const (
+	StateConnecting ConnState = iota
+	StateAuthenticating
+	StateConnected
+	StateReconnecting
+	StateFailed
+	StateClosed
+)
+
+const (
+	reconnectBaseDelay       = time.Second
+	defaultReconnectMaxDelay = 30 * time.Second
+)
+
+var (
+	ErrNotConnected = errors.New("mt5 connection is not established")
+	ErrClosed       = errors.New("mt5 connection is closed")
+)
+
+// Retcodes of rejected authentication that will not change by retrying:
+// invalid permissions, client, account or password, disabled account,
+// outdated client, missing manager config, blocked or not allowed IP and
+// wrong connection type.
+var permanentAuthRetcodes = map[int]struct{}{
+	8:    {},
+	1000: {},
+	1001: {},
+	1002: {},
+	1010: {},
+	1011: {},
+	1012: {},
+	1016: {},
+	1017: {},
+}
+
+func (s ConnState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateAuthenticating:
+		return "authenticating"
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateFailed:
+		return "failed"
+	case StateClosed:
+		return "closed"
+	}
+	return fmt.Sprintf("ConnState(%d)", int32(s))
+}
+
+// AuthError is returned when the server rejects the manager authentication.
+type AuthError struct {
+	Retcode string
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("auth retcode error: %s", e.Retcode)
+}
+
+// Permanent reports whether the rejection can only be fixed by changing the
+// configuration, e.g. a wrong password or a banned IP.
+func (e *AuthError) Permanent() bool {
+	_, ok := permanentAuthRetcodes[retcodeNumber(e.Retcode)]
+	return ok
+}
+
+// retcodeNumber returns the numeric part of a retcode like "0 Done", or -1.
+func retcodeNumber(retcode string) int {
+	fields := strings.Fields(retcode)
+	if len(fields) == 0 {
+		return -1
+	}
+	n, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+func isPermanent(err error) bool {
+	var authErr *AuthError
+	return errors.As(err, &authErr) && authErr.Permanent()
+}
+
+// State returns the current state of the connection.
+func (c *MT5Client) State() ConnState {
+	c.stateMux.Lock()
+	defer c.stateMux.Unlock()
+	return c.state
+}
+
+func (c *MT5Client) setState(state ConnState) {
+	c.stateMux.Lock()
+	defer c.stateMux.Unlock()
+	c.setStateLocked(state)
+}
+
+// setStateLocked changes the state unless the connection has been closed,
+// which is final. stateMux must be held.
+func (c *MT5Client) setStateLocked(state ConnState) {
+	if c.state == StateClosed || c.state == state {
+		return
+	}
+
+	event := &ConnStateEvent{
+		ClientId: c.clientId,
+		From:     c.state,
+		To:       state,
+		Time:     c.clock.Now(),
+	}
+	if state == StateFailed {
+		event.Err = c.failErr
+	} else if state == StateReconnecting {
+		event.Err = c.lastErr
+	}
+	c.state = state
+
+	if c.events != nil {
+		select {
+		case c.events <- event:
+		default:
+			c.log.Errorf("#%d state event %s -> %s dropped", c.clientId, event.From, event.To)
+		}
+	}
+}
+
+// activeConn returns the connection if it is ready for requests. Requests do
+// not wait for a reconnect in progress, they fail right away.
+func (c *MT5Client) activeConn() (net.Conn, error) {
+	c.stateMux.Lock()
+	state, failErr := c.state, c.failErr
+	c.stateMux.Unlock()
+
+	switch state {
+	case StateConnected:
+	case StateFailed:
+		return nil, fmt.Errorf("mt5 connection failed: %w", failErr)
+	case StateClosed:
+		return nil, ErrClosed
+	default:
+		return nil, ErrNotConnected
+	}
+
+	c.connMux.Lock()
+	defer c.connMux.Unlock()
+	if c.conn == nil {
+		return nil, ErrNotConnected
+	}
+	return c.conn, nil
+}
+
+// startReconnect drops the current connection and starts the reconnect
+// supervisor unless it is already running or the connection is closed or
+// permanently failed.
+func (c *MT5Client) startReconnect() {
+	c.stateMux.Lock()
+	if c.reconnecting || c.state == StateClosed || c.state == StateFailed {
+		c.stateMux.Unlock()
+		return
+	}
+	c.reconnecting = true
+	c.reconnects++
+	c.setStateLocked(StateReconnecting)
+	c.stateMux.Unlock()
+
+	c.connMux.Lock()
+	conn := c.conn
+	c.conn = nil
+	c.connMux.Unlock()
+	if conn != nil {
+		_ = conn.Close()
+	}
+
+	go c.superviseReconnect()
+}
+
+// superviseReconnect tries to connect again with exponential backoff and
+// jitter until it succeeds, the connection is closed, the failure is
+// permanent or the attempts/time configured for reconnecting run out.
+func (c *MT5Client) superviseReconnect() {
+	defer func() {
+		c.stateMux.Lock()
+		c.reconnecting = false
+		c.stateMux.Unlock()
+	}()
+
+	ctx, cancel := c.doneContext()
+	defer cancel()
+
+	ctx, span := c.tracer.Start(ctx, "mt5client.reconnect")
+	defer span.End()
+	span.SetAttribute(AttrConn, c.clientId)
+
+	started := c.clock.Now()
+	for attempt := 1; ; attempt++ {
+		span.SetAttribute(AttrAttempts, attempt)
+		err := c.connect(ctx)
+		if err == nil {
+			c.log.Infof("#%d reconnect successfully, attempt %d", c.clientId, attempt)
+			return
+		}
+		if errors.Is(err, ErrClosed) || ctx.Err() != nil {
+			return
+		}
+		c.recordResult(err)
+
+		if isPermanent(err) {
+			span.RecordError(err)
+			c.fail(err)
+			return
+		}
+		if c.cfg.MT5ReconnectMaxAttempts > 0 && attempt >= c.cfg.MT5ReconnectMaxAttempts {
+			err = fmt.Errorf("reconnect gave up after %d attempts: %w", attempt, err)
+			span.RecordError(err)
+			c.fail(err)
+			return
+		}
+
+		delay := reconnectBackoff(c.cfg, attempt)
+		timeout := time.Duration(c.cfg.MT5ReconnectTimeout) * time.Second
+		if timeout > 0 && c.clock.Now().Sub(started)+delay > timeout {
+			err = fmt.Errorf("reconnect gave up after %s: %w", c.clock.Now().Sub(started).Round(time.Second), err)
+			span.RecordError(err)
+			c.fail(err)
+			return
+		}
+
+		c.setState(StateReconnecting)
+		c.log.Errorf("#%d reconnect attempt %d failed, next in %s: %v", c.clientId, attempt, delay.Round(time.Millisecond), err)
+
+		select {
+		case <-c.done:
+			return
+		case <-c.clock.After(delay):
+		}
+	}
+}
+
+// failure returns the error the connection failed with, if any.
+func (c *MT5Client) failure() error {
+	c.stateMux.Lock()
+	defer c.stateMux.Unlock()
+	return c.failErr
+}
+
+func (c *MT5Client) fail(err error) {
+	c.log.Errorf("#%d connection failed, not reconnecting: %v", c.clientId, err)
+
+	c.stateMux.Lock()
+	defer c.stateMux.Unlock()
+	c.failErr = err
+	c.setStateLocked(StateFailed)
+}
+
+// reconnectBackoff returns the delay before the next attempt: the base delay
+// doubled for every failed attempt up to the configured maximum, half of it
+// random.
+func reconnectBackoff(cfg *Config, attempt int) time.Duration {
+	max := defaultReconnectMaxDelay
+	if cfg.MT5ReconnectMaxDelay > 0 {
+		max = time.Duration(cfg.MT5ReconnectMaxDelay) * time.Second
+	}
+
+	delay := max
+	if attempt < 32 {
+		if d := reconnectBaseDelay << uint(attempt-1); d > 0 && d < max {
+			delay = d
+		}
+	}
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func (c *MT5Client) closeDone() {
+	c.doneOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+// doneContext returns a context cancelled when the connection is closed.
+func (c *MT5Client) doneContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-c.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
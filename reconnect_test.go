@@ -0,0 +1,131 @@
+package mt5client
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReconnectBackoff(t *testing.T) {
+	cfg := &Config{MT5ReconnectMaxDelay: 10}
+	for attempt, base := range map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		4:  8 * time.Second,
+		5:  10 * time.Second,
+		40: 10 * time.Second,
+	} {
+		for i := 0; i < 100; i++ {
+			if d := reconnectBackoff(cfg, attempt); d < base/2 || d > base {
+				t.Fatalf("attempt %d waits %s, want between %s and %s", attempt, d, base/2, base)
+			}
+		}
+	}
+}
+
+// waitState returns the first event of the connections moving to state.
+func waitState(t *testing.T, events <-chan *ConnStateEvent, state ConnState) *ConnStateEvent {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e := <-events:
+			if e.To == state {
+				return e
+			}
+		case <-timeout:
+			t.Fatalf("no connection %s", state)
+		}
+	}
+}
+
+// droppingServer closes the connection on DROP_GET.
+func droppingServer(t *testing.T) *fakeServer {
+	return newFakeServer(t, func(cmd *MT5Command) string {
+		if cmd.Name == "DROP_GET" {
+			return ""
+		}
+		return cmd.Name + "|RETCODE=0 Done|\r\n{}"
+	})
+}
+
+func TestReconnect(t *testing.T) {
+	s := droppingServer(t)
+	pool, err := New(s.config(), WithRetryPolicy(RetryPolicy{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	events, cancel := pool.Subscribe()
+	defer cancel()
+
+	if _, err := pool.Do(context.Background(), "DROP_GET", nil, nil); err == nil {
+		t.Fatal("DROP_GET succeeded")
+	}
+	if e := waitState(t, events, StateReconnecting); e.Err == nil {
+		t.Error("reconnect event without the error of the connection")
+	}
+	waitState(t, events, StateConnected)
+
+	if _, err := pool.GetCommon(); err != nil {
+		t.Errorf("command after the reconnect: %v", err)
+	}
+	if n := s.loginCount(); n != 2 {
+		t.Errorf("%d logins, want the reconnect to authenticate again", n)
+	}
+	if h := pool.Health(); h.Connections[0].Reconnects != 1 {
+		t.Errorf("%d reconnects, want 1", h.Connections[0].Reconnects)
+	}
+}
+
+func TestReconnectPermanentFailure(t *testing.T) {
+	s := droppingServer(t)
+	pool, err := New(s.config(), WithRetryPolicy(RetryPolicy{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	events, cancel := pool.Subscribe()
+	defer cancel()
+
+	s.rejectAuth("1001 Invalid account")
+	if _, err := pool.Do(context.Background(), "DROP_GET", nil, nil); err == nil {
+		t.Fatal("DROP_GET succeeded")
+	}
+	e := waitState(t, events, StateFailed)
+	var authErr *AuthError
+	if !errors.As(e.Err, &authErr) || !authErr.Permanent() {
+		t.Errorf("connection failed with %v, want a permanent *AuthError", e.Err)
+	}
+	// The banned account is not retried.
+	if n := s.loginCount(); n != 2 {
+		t.Errorf("%d logins, want a single reconnect attempt", n)
+	}
+}
+
+func TestReconnectMaxAttempts(t *testing.T) {
+	s := droppingServer(t)
+	cfg := s.config()
+	cfg.MT5ReconnectMaxAttempts = 2
+	pool, err := New(cfg, WithRetryPolicy(RetryPolicy{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	events, cancel := pool.Subscribe()
+	defer cancel()
+
+	s.rejectAuth("3 Invalid parameters")
+	if _, err := pool.Do(context.Background(), "DROP_GET", nil, nil); err == nil {
+		t.Fatal("DROP_GET succeeded")
+	}
+	e := waitState(t, events, StateFailed)
+	if e.Err == nil || !strings.Contains(e.Err.Error(), "after 2 attempts") {
+		t.Errorf("connection failed with %v, want to give up after 2 attempts", e.Err)
+	}
+	if n := s.loginCount(); n != 3 {
+		t.Errorf("%d logins, want 2 reconnect attempts", n)
+	}
+}
@@ -0,0 +1,432 @@
+package mt5client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNoRecycleBin is returned by Restore for a pool without a recycle bin.
+var ErrNoRecycleBin = errors.New("mt5 pool has no recycle bin")
+
+// DeletedRecords are the deals deleted by a call, as read from the server
+// before deleting them.
+type DeletedRecords struct {
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+	// Caller is the identity set with WithCaller on the context of the
+	// delete.
+	Caller  string `json:"caller,omitempty"`
+	Command string `json:"command"`
+	// Deals are the JSON objects of DEAL_GET_BATCH as they are, so that no
+	// field or digit is lost.
+	Deals []json.RawMessage `json:"deals,omitempty"`
+}
+
+// RecycleBin keeps the deleted deals so that they can be restored, see
+// WithRecycleBin. Put replaces the records of the same ID. Take removes the
+// records from the bin and returns them atomically: of concurrent Takes of the
+// same id, from any process sharing the bin, a single one gets the records,
+// the others get ErrNotFound.
+type RecycleBin interface {
+	Put(records *DeletedRecords) error
+	Get(id string) (*DeletedRecords, error)
+	Take(id string) (*DeletedRecords, error)
+	Remove(id string) error
+	List() ([]*DeletedRecords, error)
+}
+
+// WithRecycleBin keeps the deals deleted by the pool in the bin. The records
+// found are put in the bin before they are deleted, and the delete is not
+// sent if that fails. Once it is done, the bin keeps only the records actually
+// deleted, see DeleteReport.RecycleID and Pool.Restore. The positions are not
+// kept, they cannot be restored, see BulkDeletePositions.
+func WithRecycleBin(b RecycleBin) Option {
+	return func(o *options) {
+		o.recycleBin = b
+	}
+}
+
+var recycleSeq uint64
+
+func newRecycleID(t time.Time) string {
+	return fmt.Sprintf("%016x-%d", t.UnixNano(), atomic.AddUint64(&recycleSeq, 1))
+}
+
+// MemoryRecycleBin keeps the records in memory, they are lost when the
+// process exits.
+type MemoryRecycleBin struct {
+	mux     sync.Mutex
+	records map[string]*DeletedRecords
+}
+
+func NewMemoryRecycleBin() *MemoryRecycleBin {
+	return &MemoryRecycleBin{records: make(map[string]*DeletedRecords)}
+}
+
+func (b *MemoryRecycleBin) Put(records *DeletedRecords) error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.records[records.ID] = records
+	return nil
+}
+
+func (b *MemoryRecycleBin) Get(id string) (*DeletedRecords, error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	records, ok := b.records[id]
+	if !ok {
+		return nil, fmt.Errorf("recycle bin %s: %w", id, ErrNotFound)
+	}
+	return records, nil
+}
+
+func (b *MemoryRecycleBin) Take(id string) (*DeletedRecords, error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	records, ok := b.records[id]
+	if !ok {
+		return nil, fmt.Errorf("recycle bin %s: %w", id, ErrNotFound)
+	}
+	delete(b.records, id)
+	return records, nil
+}
+
+func (b *MemoryRecycleBin) Remove(id string) error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	delete(b.records, id)
+	return nil
+}
+
+// List returns the records oldest first.
+func (b *MemoryRecycleBin) List() ([]*DeletedRecords, error) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	list := make([]*DeletedRecords, 0, len(b.records))
+	for _, records := range b.records {
+		list = append(list, records)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+// DirRecycleBin keeps the records of every delete in a JSON file of a
+// directory.
+type DirRecycleBin struct {
+	dir string
+}
+
+// OpenRecycleDir keeps the records in dir, creating it if needed.
+func OpenRecycleDir(dir string) (*DirRecycleBin, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &DirRecycleBin{dir: dir}, nil
+}
+
+func (b *DirRecycleBin) path(id string) string {
+	return filepath.Join(b.dir, id+".json")
+}
+
+// checkID rejects the ids that are not a file name within the directory.
+func checkID(id string) error {
+	if id == "" || id == "." || id == ".." || strings.ContainsAny(id, `/\`) {
+		return fmt.Errorf("recycle bin %q: %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+// Put writes the records to a temporary file renamed once complete.
+func (b *DirRecycleBin) Put(records *DeletedRecords) error {
+	if err := checkID(records.ID); err != nil {
+		return err
+	}
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	tmp := b.path(records.ID) + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, b.path(records.ID))
+}
+
+func (b *DirRecycleBin) Get(id string) (*DeletedRecords, error) {
+	if err := checkID(id); err != nil {
+		return nil, err
+	}
+	return readRecords(id, b.path(id))
+}
+
+// Take renames the file of the records before reading it, the rename only
+// succeeds for one caller. The records of a Take interrupted by a crash are
+// left in "<id>.json.taken".
+func (b *DirRecycleBin) Take(id string) (*DeletedRecords, error) {
+	if err := checkID(id); err != nil {
+		return nil, err
+	}
+	taken := b.path(id) + ".taken"
+	err := os.Rename(b.path(id), taken)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("recycle bin %s: %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := readRecords(id, taken)
+	if err == nil {
+		err = os.Remove(taken)
+	}
+	if err != nil {
+		// Put the records back for another try.
+		if renameErr := os.Rename(taken, b.path(id)); renameErr != nil {
+			return nil, fmt.Errorf("recycle bin %s: %v, left in %s: %v", id, err, taken, renameErr)
+		}
+		return nil, err
+	}
+	return records, nil
+}
+
+func readRecords(id, path string) (*DeletedRecords, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("recycle bin %s: %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	records := &DeletedRecords{}
+	if err := json.Unmarshal(data, records); err != nil {
+		return nil, fmt.Errorf("recycle bin %s: %v", id, err)
+	}
+	return records, nil
+}
+
+func (b *DirRecycleBin) Remove(id string) error {
+	if err := checkID(id); err != nil {
+		return err
+	}
+	err := os.Remove(b.path(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// List returns the records oldest first.
+func (b *DirRecycleBin) List() ([]*DeletedRecords, error) {
+	files, err := filepath.Glob(filepath.Join(b.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	list := make([]*DeletedRecords, 0, len(files))
+	for _, f := range files {
+		records, err := b.Get(strings.TrimSuffix(filepath.Base(f), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, records)
+	}
+	return list, nil
+}
+
+// recycle puts the records found by the snapshot in the recycle bin of the
+// pool, if any, before they are deleted.
+func (p *Pool) recycle(ctx context.Context, d *bulkDelete, report *DeleteReport, found map[string]bool) (*DeletedRecords, error) {
+	if p.opts.recycleBin == nil || d.records == nil || len(found) == 0 {
+		return nil, nil
+	}
+
+	records := d.records(report, found)
+	records.ID = newRecycleID(p.clock.Now())
+	records.Time = p.clock.Now()
+	records.Caller = CallerFrom(ctx)
+	records.Command = d.command
+	if err := p.opts.recycleBin.Put(records); err != nil {
+		return nil, fmt.Errorf("%s recycle bin: %w", d.command, err)
+	}
+	report.RecycleID = records.ID
+	return records, nil
+}
+
+// settleRecycled keeps only the records deleted, or maybe deleted if the
+// outcome is unknown, in the recycle bin.
+func (p *Pool) settleRecycled(d *bulkDelete, report *DeleteReport, records *DeletedRecords, found map[string]bool) {
+	if records == nil {
+		return
+	}
+
+	deleted := make(map[string]bool, len(found))
+	for _, res := range report.Results {
+		if res.Status == DeleteStatusDeleted || errors.Is(res.Err, ErrOutcomeUnknown) {
+			deleted[res.ID] = true
+		}
+	}
+
+	var err error
+	switch {
+	case report.Simulated || len(deleted) == 0:
+		report.RecycleID = ""
+		err = p.opts.recycleBin.Remove(records.ID)
+	case len(deleted) < len(found):
+		kept := d.records(report, deleted)
+		kept.ID, kept.Time, kept.Caller, kept.Command = records.ID, records.Time, records.Caller, records.Command
+		err = p.opts.recycleBin.Put(kept)
+	}
+	if err != nil {
+		p.log.Errorf("MT5 %s recycle bin %s: %v", d.command, records.ID, err)
+	}
+}
+
+func recycledDeals(report *DeleteReport, ids map[string]bool) *DeletedRecords {
+	records := &DeletedRecords{}
+	for i, d := range report.Deals {
+		if ids[fmt.Sprintf("%d", d.Deal)] {
+			records.Deals = append(records.Deals, report.rawDeals[i])
+		}
+	}
+	return records
+}
+
+// RestoreResult is the outcome of the restore of a deal.
+type RestoreResult struct {
+	// ID is the ticket of the deleted deal, Restored the ticket of the deal
+	// created by the server.
+	ID       string
+	Restored string
+	// Deal is the deleted deal as read from the server. It is not kept in
+	// the recycle bin if Err is an *OutcomeUnknownError: check whether it
+	// was restored before adding it again.
+	Deal json.RawMessage
+	Err  error
+}
+
+// RestoreReport describes a restore deal by deal.
+type RestoreReport struct {
+	ID      string
+	Results []RestoreResult
+	// Simulated is set if the records were not sent, see WithDryRun.
+	Simulated bool
+}
+
+// Restore re-creates the deals kept in the recycle bin under id with
+// DEAL_ADD, see DeleteReport.RecycleID. The deals are sent as read from the
+// server but for their ticket, they get new ones.
+//
+// The records are taken out of the bin before the deals are sent, see
+// RecycleBin.Take, so that a concurrent or repeated Restore, from this process
+// or another one sharing the bin, gets ErrNotFound instead of sending them
+// twice. The deals that failed are put back in the bin to try again, except
+// those with an *OutcomeUnknownError which may have been restored. The error
+// is that of the first failed deal.
+func (p *Pool) Restore(ctx context.Context, id string) (*RestoreReport, error) {
+	bin := p.opts.recycleBin
+	if bin == nil {
+		return nil, ErrNoRecycleBin
+	}
+
+	// The records stay in the bin in dry-run mode, nothing is sent.
+	take := bin.Take
+	if p.opts.dryRun {
+		take = bin.Get
+	}
+	records, err := take(id)
+	if err != nil {
+		return nil, err
+	}
+	report := &RestoreReport{ID: id}
+
+	kept := &DeletedRecords{ID: records.ID, Time: records.Time, Caller: records.Caller, Command: records.Command}
+	var firstErr error
+	for _, d := range records.Deals {
+		res, simulated := p.restoreDeal(ctx, d)
+		report.Simulated = report.Simulated || simulated
+		if res.Err != nil {
+			if firstErr == nil {
+				firstErr = res.Err
+			}
+			if !errors.Is(res.Err, ErrOutcomeUnknown) {
+				kept.Deals = append(kept.Deals, d)
+			}
+		}
+		report.Results = append(report.Results, res)
+	}
+
+	if !p.opts.dryRun && len(kept.Deals) > 0 {
+		if err := bin.Put(kept); err != nil {
+			p.log.Errorf("MT5 restore %s recycle bin: %v", id, err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("recycle bin %s: %w", id, err)
+			}
+		}
+	}
+	if firstErr != nil {
+		p.log.Warnf("MT5 restore %s: %d of %d deals failed: %v", id, len(report.Results)-countRestored(report), len(report.Results), firstErr)
+	}
+	return report, firstErr
+}
+
+// restoreDeal adds the deal again without the ticket assigned by the server
+// and reports whether the command was simulated.
+func (p *Pool) restoreDeal(ctx context.Context, d json.RawMessage) (RestoreResult, bool) {
+	res := RestoreResult{Deal: d}
+
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(d, &fields); err != nil {
+		res.Err = fmt.Errorf("recycled deal: %v", err)
+		return res, false
+	}
+	// The ticket is sent as a string or as a number.
+	res.ID = strings.Trim(string(fields["Deal"]), `"`)
+	payload, err := dealAddPayload(fields)
+	if err != nil {
+		res.Err = err
+		return res, false
+	}
+	resp, err := p.callContext(ctx, &ClientControlMessage{
+		Cmd: &MT5Command{Name: MT5CommandDealAdd, Payload: payload},
+	}, p.requestTimeout())
+	if err != nil {
+		res.Err = err
+		return res, false
+	}
+	res.Err = resp.Err
+	if deal, ok := resp.Response.(*Deal); ok && deal != nil {
+		res.Restored = fmt.Sprintf("%d", deal.Deal)
+	}
+	return res, resp.Simulated
+}
+
+// dealAddPayload is the deal without its ticket, the server assigns a new
+// one. The other fields are sent as they are.
+func dealAddPayload(fields map[string]json.RawMessage) (string, error) {
+	delete(fields, "Deal")
+	data, err := json.Marshal(fields)
+	return string(data), err
+}
+
+func countRestored(report *RestoreReport) int {
+	n := 0
+	for _, res := range report.Results {
+		if res.Err == nil {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,147 @@
+package mt5client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRestoreDeals(t *testing.T) {
+	var mux sync.Mutex
+	var added []string
+	s := newFakeServer(t, func(cmd *MT5Command) string {
+		switch cmd.Name {
+		case MT5CommandDealGetBatch:
+			return "DEAL_GET_BATCH|RETCODE=0 Done|\r\n" +
+				`[{"Deal":"1","Login":"1000","Profit":"1234567.89","Unmodeled":"kept","Comment":"ok"},{"Deal":"2","Login":"1000","Comment":"rejected"},{"Deal":"3","Login":"1000","Comment":"lost"}]`
+		case MT5CommandPositionGetBatch:
+			return "POSITION_GET_BATCH|RETCODE=0 Done|\r\n" + `[{"Position":"5","Login":"1000"}]`
+		case MT5CommandDealAdd:
+			mux.Lock()
+			added = append(added, cmd.Payload)
+			mux.Unlock()
+			switch {
+			case strings.Contains(cmd.Payload, `"rejected"`):
+				return "DEAL_ADD|RETCODE=3 Invalid parameters|\r\n"
+			case strings.Contains(cmd.Payload, `"lost"`):
+				return ""
+			}
+			return "DEAL_ADD|RETCODE=0 Done|\r\n{\"Deal\":\"11\",\"Login\":\"1000\"}"
+		}
+		return cmd.Name + "|RETCODE=0 Done|\r\n"
+	})
+	bin := NewMemoryRecycleBin()
+	pool, err := New(s.config(), WithRecycleBin(bin))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	deleted, err := pool.BulkDeleteDeals(context.Background(), []uint64{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := pool.Restore(context.Background(), deleted.RecycleID)
+	var retcodeErr *RetcodeError
+	if !errors.As(err, &retcodeErr) {
+		t.Fatalf("Restore returned %v, want the *RetcodeError of deal 2", err)
+	}
+
+	want := map[string]func(res RestoreResult) bool{
+		"1": func(res RestoreResult) bool { return res.Err == nil && res.Restored == "11" },
+		"2": func(res RestoreResult) bool { return errors.As(res.Err, &retcodeErr) },
+		"3": func(res RestoreResult) bool { return errors.Is(res.Err, ErrOutcomeUnknown) },
+	}
+	for _, res := range report.Results {
+		if check := want[res.ID]; check == nil || !check(res) {
+			t.Errorf("deal %s restored as %q: %v", res.ID, res.Restored, res.Err)
+		}
+	}
+
+	mux.Lock()
+	for _, payload := range added {
+		if strings.Contains(payload, `"Deal"`) {
+			t.Errorf("DEAL_ADD sent with the deleted ticket: %s", payload)
+		}
+	}
+	if len(added) == 0 || !strings.Contains(added[0], `"Profit":"1234567.89"`) || !strings.Contains(added[0], `"Unmodeled":"kept"`) {
+		t.Errorf("DEAL_ADD did not send the deal as read: %v", added)
+	}
+	mux.Unlock()
+
+	kept, err := bin.Get(deleted.RecycleID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kept.Deals) != 1 || !strings.Contains(string(kept.Deals[0]), `"Deal":"2"`) {
+		t.Errorf("recycle bin keeps %s, want only deal 2", kept.Deals)
+	}
+
+	positions, err := pool.BulkDeletePositions(context.Background(), []uint64{5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if positions.RecycleID != "" {
+		t.Errorf("positions put in the recycle bin as %s", positions.RecycleID)
+	}
+}
+
+func TestDirRecycleBinTake(t *testing.T) {
+	dir := t.TempDir()
+	bins := make([]*DirRecycleBin, 2)
+	for i := range bins {
+		bin, err := OpenRecycleDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bins[i] = bin
+	}
+	if err := bins[0].Put(&DeletedRecords{ID: "1", Deals: []json.RawMessage{json.RawMessage(`{"Deal":"1"}`)}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two processes sharing the directory, one only takes the records.
+	var wg sync.WaitGroup
+	results := make([]error, len(bins))
+	for i, bin := range bins {
+		wg.Add(1)
+		go func(i int, bin *DirRecycleBin) {
+			defer wg.Done()
+			_, results[i] = bin.Take("1")
+		}(i, bin)
+	}
+	wg.Wait()
+	taken := 0
+	for _, err := range results {
+		switch {
+		case err == nil:
+			taken++
+		case !errors.Is(err, ErrNotFound):
+			t.Errorf("Take returned %v, want ErrNotFound", err)
+		}
+	}
+	if taken != 1 {
+		t.Errorf("records taken %d times", taken)
+	}
+	if list, err := bins[1].List(); err != nil || len(list) != 0 {
+		t.Errorf("bin lists %d records after Take: %v", len(list), err)
+	}
+}
+
+func TestDirRecycleBinIDs(t *testing.T) {
+	bin, err := OpenRecycleDir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"", "..", "../escaped", `a\b`} {
+		if err := bin.Put(&DeletedRecords{ID: id}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Put(%q) returned %v, want ErrNotFound", id, err)
+		}
+		if err := bin.Remove(id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Remove(%q) returned %v, want ErrNotFound", id, err)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package mt5client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const redactedValue = "***"
+
+// DefaultSensitiveKeys are the parameters and JSON keys masked by
+// NewRedactor: passwords, authentication secrets, names, contact details and
+// the identity document number (ID) of the users.
+var DefaultSensitiveKeys = []string{
+	"PASS_MAIN",
+	"PASS_INVESTOR",
+	"PASSWORD",
+	"PHONE",
+	"PHONE_PASSWORD",
+	"EMAIL",
+	"NAME",
+	"FirstName",
+	"LastName",
+	"MiddleName",
+	"Address",
+	"City",
+	"ZipCode",
+	"State",
+	"ID",
+	"SRV_RAND",
+	"SRV_RAND_ANSWER",
+	"CLI_RAND",
+	"CLI_RAND_ANSWER",
+}
+
+// DefaultAllowedKeys are the parameters and JSON keys left readable by
+// NewAllowListRedactor.
+var DefaultAllowedKeys = []string{
+	MT5RetCode,
+	"LOGIN",
+	"GROUP",
+	"TICKET",
+	"SYMBOL",
+	"TYPE",
+	"TOTAL",
+	"OFFSET",
+	"FROM",
+	"TO",
+	"DEAL",
+	"ORDER",
+	"POSITION",
+	"RIGHTS",
+	"LEVERAGE",
+	"VERSION",
+	"AGENT",
+	"CRYPT_METHOD",
+}
+
+// DefaultCommandKeys are the parameters and JSON keys left readable by both
+// redactors in the given commands only: the ID of the dealer requests, which
+// is the identity document number in the users.
+var DefaultCommandKeys = map[string][]string{
+	MT5CommandDealerSend:    {"ID"},
+	MT5CommandDealerUpdates: {"ID"},
+}
+
+// Redactor masks secrets and personal data in the logged commands. Keys match
+// both MT5 parameter names and JSON keys of payloads, ignoring case and
+// underscores, so "PASS_MAIN" also masks "PassMain". A nil *Redactor logs
+// everything as is.
+type Redactor struct {
+	keys      map[string]struct{}
+	allowList bool
+	// commandKeys are the keys left readable by command name, see
+	// DefaultCommandKeys.
+	commandKeys map[string]map[string]struct{}
+}
+
+// NewRedactor masks DefaultSensitiveKeys and keys.
+func NewRedactor(keys ...string) *Redactor {
+	return newRedactor(false, append(append([]string{}, DefaultSensitiveKeys...), keys...))
+}
+
+// NewAllowListRedactor masks everything except DefaultAllowedKeys and keys,
+// payloads that are not JSON are masked entirely. Meant for production where
+// new sensitive fields must not leak by default.
+func NewAllowListRedactor(keys ...string) *Redactor {
+	return newRedactor(true, append(append([]string{}, DefaultAllowedKeys...), keys...))
+}
+
+func newRedactor(allowList bool, keys []string) *Redactor {
+	r := &Redactor{
+		keys:        make(map[string]struct{}, len(keys)),
+		allowList:   allowList,
+		commandKeys: make(map[string]map[string]struct{}, len(DefaultCommandKeys)),
+	}
+	for _, k := range keys {
+		r.keys[normalizeKey(k)] = struct{}{}
+	}
+	for command, keys := range DefaultCommandKeys {
+		r.commandKeys[command] = make(map[string]struct{}, len(keys))
+		for _, k := range keys {
+			r.commandKeys[command][normalizeKey(k)] = struct{}{}
+		}
+	}
+	return r
+}
+
+func normalizeKey(key string) string {
+	return strings.ToUpper(strings.Replace(key, "_", "", -1))
+}
+
+// masks reports whether the value of key is masked in the command named
+// command, empty if unknown.
+func (r *Redactor) masks(command, key string) bool {
+	key = normalizeKey(key)
+	if _, ok := r.commandKeys[command][key]; ok {
+		return false
+	}
+	_, ok := r.keys[key]
+	return ok != r.allowList
+}
+
+// Params returns a copy of params with the masked values replaced.
+func (r *Redactor) Params(params map[string]string) map[string]string {
+	return r.commandParams("", params)
+}
+
+// commandParams is Params for the parameters of the command named command.
+func (r *Redactor) commandParams(command string, params map[string]string) map[string]string {
+	if r == nil {
+		return params
+	}
+	redacted := make(map[string]string, len(params))
+	for k, v := range params {
+		if r.masks(command, k) {
+			v = redactedValue
+		}
+		redacted[k] = v
+	}
+	return redacted
+}
+
+// Body masks the parameters and the payload of a request body, e.g.
+// "USER_ADD|LOGIN=1|PASS_MAIN=secret|\r\n".
+func (r *Redactor) Body(body string) string {
+	if r == nil {
+		return body
+	}
+
+	command, payload := body, ""
+	hasPayload := false
+	if i := strings.Index(body, MT5PacketSeparator); i >= 0 {
+		command, payload = body[:i], body[i+len(MT5PacketSeparator):]
+		hasPayload = true
+	}
+
+	parts := splitEscaped(command, '|')
+	name := unescapeParam(parts[0])
+	for i := 1; i < len(parts); i++ {
+		kv := splitEscaped(parts[i], '=')
+		if len(kv) < 2 {
+			continue
+		}
+		if r.masks(name, unescapeParam(kv[0])) {
+			parts[i] = kv[0] + "=" + redactedValue
+		}
+	}
+
+	redacted := strings.Join(parts, "|")
+	if hasPayload {
+		redacted += MT5PacketSeparator + r.commandPayload(name, payload)
+	}
+	return redacted
+}
+
+// Payload masks the values of the masked keys in a JSON payload, at any
+// depth. Other payloads are masked entirely in allow-list mode and kept
+// otherwise.
+func (r *Redactor) Payload(payload string) string {
+	return r.commandPayload("", payload)
+}
+
+// commandPayload is Payload for the payload of the command named command.
+func (r *Redactor) commandPayload(command, payload string) string {
+	if r == nil || strings.TrimSpace(payload) == "" {
+		return payload
+	}
+
+	d := json.NewDecoder(strings.NewReader(payload))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		if r.allowList {
+			return redactedValue
+		}
+		return payload
+	}
+
+	var b bytes.Buffer
+	r.writeJSON(&b, command, v)
+	return b.String()
+}
+
+// writeJSON writes v with the masked values replaced, keeping object keys
+// sorted like encoding/json does for maps.
+func (r *Redactor) writeJSON(b *bytes.Buffer, command string, v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		b.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			key, _ := json.Marshal(k)
+			b.Write(key)
+			b.WriteByte(':')
+			if _, nested := v[k].(map[string]interface{}); !nested && r.masksValue(command, k, v[k]) {
+				b.WriteString(`"` + redactedValue + `"`)
+				continue
+			}
+			r.writeJSON(b, command, v[k])
+		}
+		b.WriteByte('}')
+	case []interface{}:
+		b.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			r.writeJSON(b, command, item)
+		}
+		b.WriteByte(']')
+	default:
+		value, _ := json.Marshal(v)
+		b.Write(value)
+	}
+}
+
+// masksValue reports whether the value of key is masked. Arrays are masked
+// as a whole only by a deny list, an allow list looks into their items.
+func (r *Redactor) masksValue(command, key string, value interface{}) bool {
+	if _, ok := value.([]interface{}); ok && r.allowList {
+		return false
+	}
+	return r.masks(command, key)
+}
+
+// The lazy values below are redacted only if the logger writes the record.
+
+type redactedBody struct {
+	r    *Redactor
+	body string
+}
+
+func (v redactedBody) String() string {
+	return v.r.Body(v.body)
+}
+
+type redactedParams struct {
+	r       *Redactor
+	command string
+	params  map[string]string
+}
+
+func (v redactedParams) String() string {
+	return fmt.Sprintf("%v", v.r.commandParams(v.command, v.params))
+}
+
+type redactedPayload struct {
+	r       *Redactor
+	payload string
+}
+
+func (v redactedPayload) String() string {
+	return v.r.Payload(v.payload)
+}
+
+func (r *Redactor) body(body string) fmt.Stringer {
+	return redactedBody{r, body}
+}
+
+func (r *Redactor) params(command string, params map[string]string) fmt.Stringer {
+	return redactedParams{r, command, params}
+}
+
+func (r *Redactor) payload(payload string) fmt.Stringer {
+	return redactedPayload{r, payload}
+}
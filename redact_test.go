@@ -0,0 +1,56 @@
+package mt5client
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedactorBody(t *testing.T) {
+	tests := []struct {
+		r      *Redactor
+		body   string
+		masked []string
+		kept   []string
+	}{
+		{
+			r:      NewRedactor(),
+			body:   "USER_ADD|LOGIN=1000|PASS_MAIN=secret|\r\n" + `{"Name":"John Smith","FirstName":"John","City":"Limassol","ID":"AB123456","Group":"demo"}`,
+			masked: []string{"secret", "John", "Limassol", "AB123456"},
+			kept:   []string{"LOGIN=1000", `"Group":"demo"`},
+		},
+		{
+			r:      NewAllowListRedactor(),
+			body:   "USER_UPDATE|LOGIN=1000|ID=AB123456|\r\n" + `{"Address":"1 Main St","ZipCode":"3025","Login":"1000"}`,
+			masked: []string{"AB123456", "Main St", "3025"},
+			kept:   []string{"LOGIN=1000", `"Login":"1000"`},
+		},
+		{
+			r:    NewRedactor(),
+			body: "DEALER_UPDATES|ID=7|\r\n",
+			kept: []string{"ID=7"},
+		},
+		{
+			r:      NewAllowListRedactor(),
+			body:   "DEALER_SEND|ID=7|\r\n" + `{"ID":"7","Login":"1000","Comment":"private"}`,
+			masked: []string{"private"},
+			kept:   []string{"ID=7", `"ID":"7"`, `"Login":"1000"`},
+		},
+	}
+	for _, tt := range tests {
+		redacted := tt.r.Body(tt.body)
+		for _, s := range tt.masked {
+			if strings.Contains(redacted, s) {
+				t.Errorf("%q not masked in %s", s, redacted)
+			}
+		}
+		for _, s := range tt.kept {
+			if !strings.Contains(redacted, s) {
+				t.Errorf("%q masked in %s", s, redacted)
+			}
+		}
+	}
+
+	if params := NewRedactor().Params(map[string]string{"ID": "AB123456"}); params["ID"] != redactedValue {
+		t.Errorf("ID out of a dealer command not masked: %v", params)
+	}
+}
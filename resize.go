@@ -0,0 +1,261 @@
+package mt5client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const poolMaintenanceInterval = 10 * time.Second
+
+var ErrNoConnections = errors.New("mt5 pool has no connections")
+
+type clientSet struct {
+	mux  sync.RWMutex
+	list []*Client
+	// nextId is the next unused client id, freeIds are the ids of the
+	// connections removed, reused first so that the ids stay the slots
+	// 0..MT5PoolSize-1 the Dedicated balancer reserves.
+	nextId  int
+	freeIds []int
+	// growing is the number of connections being dialed.
+	growing int
+	closed  bool
+	// failures counts the dials failed in a row, no connection is dialed
+	// before retryAt. failErr is the permanent failure that stops dialing,
+	// see isPermanent.
+	failures int
+	retryAt  time.Time
+	lastErr  error
+	failErr  error
+}
+
+// grow dials up to n new connections in parallel without exceeding the
+// maximum pool size, ctx cancels the dials. It returns the number of
+// connections added and the last dial error. Like the reconnects, dialing
+// backs off after failures and stops for good on a permanent one or once
+// MT5ReconnectMaxAttempts dials failed in a row.
+func (p *Pool) grow(ctx context.Context, n int) (int, error) {
+	p.clients.mux.Lock()
+	if p.clients.closed {
+		p.clients.mux.Unlock()
+		return 0, ErrClosed
+	}
+	if err := p.dialBlockedLocked(); err != nil {
+		p.clients.mux.Unlock()
+		return 0, err
+	}
+	if free := p.poolSize - len(p.clients.list) - p.clients.growing; n > free {
+		n = free
+	}
+	if n <= 0 {
+		p.clients.mux.Unlock()
+		return 0, nil
+	}
+	ids := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		ids = append(ids, p.takeIdLocked())
+	}
+	p.clients.growing += n
+	p.clients.mux.Unlock()
+
+	var (
+		wg      sync.WaitGroup
+		mux     sync.Mutex
+		added   int
+		lastErr error
+	)
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			c, err := p.newClient(ctx, id)
+
+			p.clients.mux.Lock()
+			p.clients.growing--
+			closed := p.clients.closed
+			if err == nil && !closed {
+				atomic.StoreInt64(&c.lastUsed, p.clock.Now().UnixNano())
+				p.clients.list = append(p.clients.list, c)
+			} else {
+				p.releaseIdLocked(id)
+			}
+			p.clients.mux.Unlock()
+
+			if err == nil && closed {
+				c.handler.Quit()
+				err = ErrClosed
+			}
+
+			mux.Lock()
+			defer mux.Unlock()
+			if err != nil {
+				p.log.Errorf("MT5 pool connection #%d failed: %v", id, err)
+				lastErr = err
+				return
+			}
+			added++
+		}(id)
+	}
+	wg.Wait()
+
+	if lastErr != nil && !errors.Is(lastErr, ErrClosed) {
+		p.dialFailed(added, lastErr)
+	} else if added > 0 {
+		p.clients.mux.Lock()
+		p.clients.failures = 0
+		p.clients.mux.Unlock()
+	}
+	return added, lastErr
+}
+
+// takeIdLocked returns the lowest free client id. clients.mux must be held.
+func (p *Pool) takeIdLocked() int {
+	if len(p.clients.freeIds) == 0 {
+		id := p.clients.nextId
+		p.clients.nextId++
+		return id
+	}
+	id := p.clients.freeIds[0]
+	p.clients.freeIds = p.clients.freeIds[1:]
+	return id
+}
+
+// releaseIdLocked frees the id of a connection removed or not dialed.
+// clients.mux must be held.
+func (p *Pool) releaseIdLocked(id int) {
+	i := sort.SearchInts(p.clients.freeIds, id)
+	p.clients.freeIds = append(p.clients.freeIds, 0)
+	copy(p.clients.freeIds[i+1:], p.clients.freeIds[i:])
+	p.clients.freeIds[i] = id
+}
+
+// reapFailedLocked stops dialing if the connection failed permanently and
+// backs off the dial replacing it otherwise. clients.mux must be held.
+func (p *Pool) reapFailedLocked(c *MT5Client) {
+	err := c.failure()
+	p.clients.lastErr = err
+	if isPermanent(err) {
+		if p.clients.failErr == nil {
+			p.clients.failErr = err
+			p.log.Errorf("MT5 pool stops dialing, connection #%d failed: %v", c.clientId, err)
+		}
+		return
+	}
+	p.clients.failures++
+	p.clients.retryAt = p.clock.Now().Add(reconnectBackoff(p.cfg, p.clients.failures))
+}
+
+// dialBlockedLocked returns the error of a permanent dial failure or of the
+// last failure while backing off. clients.mux must be held.
+func (p *Pool) dialBlockedLocked() error {
+	if p.clients.failErr != nil {
+		return fmt.Errorf("%w: %v", ErrNoConnections, p.clients.failErr)
+	}
+	if wait := p.clients.retryAt.Sub(p.clock.Now()); wait > 0 {
+		return fmt.Errorf("%w, next dial in %s: %v", ErrNoConnections, wait.Round(time.Millisecond), p.clients.lastErr)
+	}
+	return nil
+}
+
+// dialFailed records a failed grow: the pool stops dialing if the failure is
+// permanent or the attempts ran out, and backs off otherwise.
+func (p *Pool) dialFailed(added int, err error) {
+	p.clients.mux.Lock()
+	defer p.clients.mux.Unlock()
+
+	p.clients.lastErr = err
+	if added > 0 {
+		p.clients.failures = 0
+		return
+	}
+	p.clients.failures++
+	switch {
+	case isPermanent(err):
+		p.clients.failErr = err
+	case p.cfg.MT5ReconnectMaxAttempts > 0 && p.clients.failures >= p.cfg.MT5ReconnectMaxAttempts:
+		p.clients.failErr = fmt.Errorf("dial gave up after %d attempts: %w", p.clients.failures, err)
+	default:
+		p.clients.retryAt = p.clock.Now().Add(reconnectBackoff(p.cfg, p.clients.failures))
+		return
+	}
+	p.log.Errorf("MT5 pool stops dialing: %v", p.clients.failErr)
+}
+
+func (p *Pool) newClient(ctx context.Context, id int) (*Client, error) {
+	controlCh := make(chan *ClientControlMessage)
+
+	h, err := newMT5Client(ctx, id, p.cfg, p.opts, controlCh, p.events)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		handler:   h,
+		controlCh: controlCh,
+	}, nil
+}
+
+// maintain periodically closes idle and permanently failed connections and
+// dials new ones when the pool falls below its minimum size.
+func (p *Pool) maintain() {
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-p.clock.After(poolMaintenanceInterval):
+		}
+
+		for _, c := range p.reap() {
+			p.log.Infof("MT5 pool closing connection #%d (%s)", c.handler.clientId, c.handler.State())
+			c.handler.Quit()
+		}
+
+		p.clients.mux.RLock()
+		missing := p.minSize - len(p.clients.list) - p.clients.growing
+		p.clients.mux.RUnlock()
+		if missing > 0 {
+			_, _ = p.grow(p.dialCtx, missing)
+		}
+	}
+}
+
+// reap removes the connections to close from the pool: permanently failed
+// ones and, while the pool is above its minimum size, the ones idle for
+// longer than MT5PoolIdleTimeout. Connections with commands in flight are
+// never removed.
+func (p *Pool) reap() []*Client {
+	idleTimeout := time.Duration(p.cfg.MT5PoolIdleTimeout) * time.Second
+	now := p.clock.Now().UnixNano()
+
+	p.clients.mux.Lock()
+	defer p.clients.mux.Unlock()
+
+	size := len(p.clients.list)
+	kept := make([]*Client, 0, size)
+	removed := make([]*Client, 0)
+	for _, c := range p.clients.list {
+		if atomic.LoadInt64(&c.handler.inFlight) == 0 {
+			failed := c.handler.State() == StateFailed
+			if failed {
+				p.reapFailedLocked(c.handler)
+			}
+			idle := idleTimeout > 0 && size > p.minSize &&
+				time.Duration(now-atomic.LoadInt64(&c.lastUsed)) > idleTimeout
+			if failed || idle {
+				removed = append(removed, c)
+				p.releaseIdLocked(c.handler.clientId)
+				size--
+				continue
+			}
+		}
+		kept = append(kept, c)
+	}
+	p.clients.list = kept
+
+	return removed
+}
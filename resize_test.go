@@ -0,0 +1,125 @@
+package mt5client
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync/atomic"
+	"testing"
+)
+
+func clientIds(p *Pool) []int {
+	ids := make([]int, 0)
+	for _, c := range p.Health().Connections {
+		ids = append(ids, c.ClientId)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func TestPoolReusesClientIds(t *testing.T) {
+	s := newFakeServer(t, func(cmd *MT5Command) string {
+		return cmd.Name + "|RETCODE=0 Done|\r\n"
+	})
+	cfg := s.config()
+	cfg.MT5PoolSize = 3
+	cfg.MT5PoolMinSize = 1
+	cfg.MT5PoolIdleTimeout = 60
+	pool, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	if added, err := pool.grow(context.Background(), 2); added != 2 || err != nil {
+		t.Fatalf("grow added %d: %v", added, err)
+	}
+	if ids := clientIds(pool); len(ids) != 3 || ids[0] != 0 || ids[2] != 2 {
+		t.Fatalf("client ids %v, want [0 1 2]", ids)
+	}
+
+	// Connection 1 is idle for long, the pool is above its minimum size.
+	pool.clients.mux.RLock()
+	for _, c := range pool.clients.list {
+		if c.handler.clientId == 1 {
+			atomic.StoreInt64(&c.lastUsed, 0)
+		}
+	}
+	pool.clients.mux.RUnlock()
+	removed := pool.reap()
+	if len(removed) != 1 || removed[0].handler.clientId != 1 {
+		t.Fatalf("reaped %d connections, want #1", len(removed))
+	}
+	removed[0].handler.Quit()
+
+	if added, err := pool.grow(context.Background(), 1); added != 1 || err != nil {
+		t.Fatalf("grow added %d: %v", added, err)
+	}
+	if ids := clientIds(pool); len(ids) != 3 || ids[1] != 1 {
+		t.Errorf("client ids %v after replacing #1, want [0 1 2]", ids)
+	}
+
+	b := Dedicated(map[CommandClass][]int{CommandClassTradeWrite: {1}}, LeastInFlight())
+	conns := pool.Health().Connections
+	if i := b.Pick(CommandClassTradeWrite, conns); conns[i].ClientId != 1 {
+		t.Errorf("trade write picked #%d, want the dedicated #1", conns[i].ClientId)
+	}
+}
+
+func TestPoolStopsDialingOnPermanentFailure(t *testing.T) {
+	s := newFakeServer(t, func(cmd *MT5Command) string {
+		return cmd.Name + "|RETCODE=0 Done|\r\n"
+	})
+	cfg := s.config()
+	cfg.MT5PoolSize = 2
+	cfg.MT5PoolMinSize = 1
+	pool, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	s.rejectAuth("1001 Invalid account")
+	if _, err := pool.grow(context.Background(), 1); err == nil {
+		t.Fatal("grow succeeded with a rejected login")
+	}
+	logins := s.loginCount()
+	_, err = pool.grow(context.Background(), 1)
+	if !errors.Is(err, ErrNoConnections) {
+		t.Errorf("grow after a permanent failure returned %v, want ErrNoConnections", err)
+	}
+	if n := s.loginCount(); n != logins {
+		t.Errorf("%d logins after a permanent failure", n-logins)
+	}
+}
+
+func TestAcquireWithoutConnections(t *testing.T) {
+	s := newFakeServer(t, func(cmd *MT5Command) string {
+		return cmd.Name + "|RETCODE=0 Done|\r\n"
+	})
+	pool, err := New(s.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	removed := pool.clients.list
+	pool.clients.mux.Lock()
+	pool.clients.list = nil
+	pool.clients.mux.Unlock()
+	for _, c := range removed {
+		c.handler.Quit()
+	}
+	// The background dial backs off, the pool knows it cannot dial.
+	pool.clients.mux.Lock()
+	pool.clients.failErr = errors.New("banned")
+	pool.clients.mux.Unlock()
+
+	logins := s.loginCount()
+	if _, err := pool.acquire(&MT5Command{Name: MT5CommandCommonGet}, nil); !errors.Is(err, ErrNoConnections) {
+		t.Errorf("acquire returned %v, want ErrNoConnections", err)
+	}
+	if n := s.loginCount(); n != logins {
+		t.Errorf("acquire dialed %d connections", n-logins)
+	}
+}
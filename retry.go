@@ -0,0 +1,119 @@
+package mt5client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrOutcomeUnknown matches the *OutcomeUnknownError of every command.
+var ErrOutcomeUnknown = errors.New("mt5 command outcome unknown")
+
+// OutcomeUnknownError is returned when the connection broke after a command
+// that is not safe to repeat had been sent: the server may or may not have
+// executed it. Check the state on the server, e.g. the deals or the user of
+// the login, before sending the command again.
+type OutcomeUnknownError struct {
+	Command string
+	Err     error
+}
+
+func (e *OutcomeUnknownError) Error() string {
+	return fmt.Sprintf("%s outcome unknown: %v", e.Command, e.Err)
+}
+
+func (e *OutcomeUnknownError) Unwrap() error {
+	return e.Err
+}
+
+func (e *OutcomeUnknownError) Is(target error) bool {
+	return target == ErrOutcomeUnknown
+}
+
+// Idempotent reports whether the command can be sent again without changing
+// the result, which holds for the reads. Writes such as TRADE_BALANCE,
+// DEALER_SEND or USER_ADD would be executed twice.
+func Idempotent(command string) bool {
+	return ClassOf(command) == CommandClassRead
+}
+
+// RetryPolicy configures how the commands failing with a transport error are
+// sent again on another connection. Idempotent commands are retried whenever
+// the connection breaks, the others only if they never left the client.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, 1 disables retries.
+	MaxAttempts int
+	// Backoff is the delay before the second attempt, doubled for every
+	// further one.
+	Backoff time.Duration
+}
+
+// DefaultRetryPolicy is used unless changed with WithRetryPolicy.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	Backoff:     100 * time.Millisecond,
+}
+
+// WithRetryPolicy sets the retry policy of the pool.
+func WithRetryPolicy(r RetryPolicy) Option {
+	return func(o *options) {
+		o.retry = r
+	}
+}
+
+// retry sends the message again on a connection it has not failed on yet,
+// after the backoff of the policy. It reports false if err is not a transport
+// error, the attempts ran out, the call is abandoned or its context done, the
+// command may have reached the server and is not idempotent, or items of its
+// stream were passed on already.
+func (p *Pool) retry(m *ClientControlMessage, clientId int, err error) bool {
+	var transportErr *TransportError
+	if !errors.As(err, &transportErr) || m.attempt+1 >= p.retryPolicy.MaxAttempts || m.streamed {
+		return false
+	}
+	if transportErr.Sent && !Idempotent(m.Cmd.Name) {
+		return false
+	}
+	parent := m.parent
+	if parent == nil {
+		parent = context.Background()
+	}
+	if parent.Err() != nil || m.isAbandoned() {
+		return false
+	}
+
+	next := &ClientControlMessage{
+		Cmd:     m.Cmd,
+		Cb:      m.Cb,
+		Stream:  m.Stream,
+		attempt: m.attempt + 1,
+		tried:   append(m.tried[:len(m.tried):len(m.tried)], clientId),
+		call:    m.call,
+		outbox:  m.outbox,
+		raw:     m.raw,
+	}
+	delay := p.retryPolicy.Backoff << uint(m.attempt)
+	p.log.Warnf("MT5 %s failed on #%d, attempt %d of %d in %s: %v", m.Cmd.Name, clientId, next.attempt+1, p.retryPolicy.MaxAttempts, delay, err)
+
+	go func() {
+		select {
+		case <-p.clock.After(delay):
+			p.send(parent, next)
+		case <-m.abandonedCh():
+		case <-parent.Done():
+			m.deliver(&ClientResponse{Cmd: m.Cmd, Err: parent.Err()}, p.clock, p.requestTimeout())
+		}
+	}()
+	return true
+}
+
+// outcomeUnknown wraps the transport error of a command that may have been
+// executed by the server and is not safe to repeat.
+func outcomeUnknown(command string, err error) error {
+	var transportErr *TransportError
+	if errors.As(err, &transportErr) && transportErr.Sent && !Idempotent(command) {
+		return &OutcomeUnknownError{Command: command, Err: err}
+	}
+	return err
+}
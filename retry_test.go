@@ -0,0 +1,89 @@
+package mt5client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// flakyServer closes the connection on the first command of each name.
+func flakyServer(t *testing.T) *fakeServer {
+	var mux sync.Mutex
+	dropped := make(map[string]bool)
+	return newFakeServer(t, func(cmd *MT5Command) string {
+		mux.Lock()
+		defer mux.Unlock()
+		if !dropped[cmd.Name] {
+			dropped[cmd.Name] = true
+			return ""
+		}
+		return cmd.Name + "|RETCODE=0 Done|\r\n{}"
+	})
+}
+
+func TestRetry(t *testing.T) {
+	s := flakyServer(t)
+	cfg := s.config()
+	cfg.MT5PoolSize = 2
+	pool, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	if _, err := pool.GetCommon(); err != nil {
+		t.Fatalf("read not retried: %v", err)
+	}
+	if n := s.count(MT5CommandCommonGet); n != 2 {
+		t.Errorf("%d COMMON_GET sent, want a retry", n)
+	}
+
+	// The server may have executed the write before the connection broke.
+	_, err = pool.Do(context.Background(), MT5CommandTradeBalance, map[string]string{"LOGIN": "1000"}, nil)
+	var unknown *OutcomeUnknownError
+	if !errors.As(err, &unknown) || !errors.Is(err, ErrOutcomeUnknown) || unknown.Command != MT5CommandTradeBalance {
+		t.Errorf("write returned %v, want an *OutcomeUnknownError", err)
+	}
+	var transportErr *TransportError
+	if !errors.As(err, &transportErr) || !transportErr.Sent {
+		t.Errorf("write returned %v, want the transport error of the sent request", err)
+	}
+	if n := s.count(MT5CommandTradeBalance); n != 1 {
+		t.Errorf("%d TRADE_BALANCE sent, want the write not repeated", n)
+	}
+}
+
+func TestRetryDisabled(t *testing.T) {
+	s := flakyServer(t)
+	cfg := s.config()
+	cfg.MT5PoolSize = 2
+	pool, err := New(cfg, WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	var transportErr *TransportError
+	if _, err := pool.GetCommon(); !errors.As(err, &transportErr) {
+		t.Errorf("read returned %v, want the transport error", err)
+	}
+	if n := s.count(MT5CommandCommonGet); n != 1 {
+		t.Errorf("%d COMMON_GET sent, want no retry", n)
+	}
+}
+
+func TestIdempotent(t *testing.T) {
+	for command, want := range map[string]bool{
+		MT5CommandCommonGet:    true,
+		MT5CommandUserGet:      true,
+		MT5CommandTradeBalance: false,
+		MT5CommandDealerSend:   false,
+		MT5CommandUserAdd:      false,
+		"UNKNOWN_GET":          false,
+	} {
+		if got := Idempotent(command); got != want {
+			t.Errorf("Idempotent(%s) = %t, want %t", command, got, want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package mt5client
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const serverTimeLayout = "2006.01.02 15:04:05"
+
+func (p *Pool) GetCommon() (*CommonConfig, error) {
+	resp, err := p.call(&ClientControlMessage{
+		Cmd: &MT5Command{
+			Name: MT5CommandCommonGet,
+		},
+	}, p.requestTimeout())
+	if err != nil {
+		return nil, err
+	}
+	if resp.Err != nil {
+		return nil, resp.Err
+	}
+	return resp.Response.(*CommonConfig), nil
+}
+
+func (p *Pool) GetTimeConfig() (*TimeConfig, error) {
+	resp, err := p.call(&ClientControlMessage{
+		Cmd: &MT5Command{
+			Name: MT5CommandTimeGet,
+		},
+	}, p.requestTimeout())
+	if err != nil {
+		return nil, err
+	}
+	if resp.Err != nil {
+		return nil, resp.Err
+	}
+	return resp.Response.(*TimeConfig), nil
+}
+
+// GetServerTime returns the current trade server time. Like every timestamp
+// received from MT5 it is expressed in the server time zone, use
+// TimeConfig.ToUTC to convert it.
+func (p *Pool) GetServerTime() (int64, error) {
+	resp, err := p.call(&ClientControlMessage{
+		Cmd: &MT5Command{
+			Name: MT5CommandTimeServer,
+		},
+	}, p.requestTimeout())
+	if err != nil {
+		return 0, err
+	}
+	if resp.Err != nil {
+		return 0, resp.Err
+	}
+	return resp.Response.(int64), nil
+}
+
+// Offset returns the difference between the server time and UTC. TIME_GET
+// does not return the daylight saving rules of the server, only whether DST
+// is in effect now, so the current state is applied to every timestamp.
+func (t *TimeConfig) Offset() time.Duration {
+	offset := time.Duration(t.TimeZone) * time.Minute
+	if t.DaylightState != 0 {
+		offset += time.Hour
+	}
+	return offset
+}
+
+// ToUTC converts a server timestamp in seconds to UTC with the current
+// Offset. It is exact for timestamps on the same side of the last DST switch
+// as now, such as GetServerTime or recent deals. Older Deal.Time,
+// Position.TimeCreate or Order.TimeSetup values from the other side of a
+// switch come out one hour off, convert them with the rules of the server
+// time zone instead when the hour matters.
+func (t *TimeConfig) ToUTC(serverTime int64) time.Time {
+	return time.Unix(serverTime, 0).Add(-t.Offset()).UTC()
+}
+
+// ToUTCMsc converts a server timestamp in milliseconds, such as Deal.TimeMsc,
+// to UTC. Like ToUTC it applies the current DST state.
+func (t *TimeConfig) ToUTCMsc(serverTimeMsc int64) time.Time {
+	return time.Unix(0, serverTimeMsc*int64(time.Millisecond)).Add(-t.Offset()).UTC()
+}
+
+// FromUTC converts a UTC time to a server timestamp in seconds, suitable for
+// FROM/TO request parameters. Like ToUTC it applies the current DST state,
+// so a bound on the other side of a DST switch is shifted by one hour.
+func (t *TimeConfig) FromUTC(utc time.Time) int64 {
+	return utc.Add(t.Offset()).Unix()
+}
+
+// parseServerTime accepts both a unix timestamp and the "YYYY.MM.DD HH:MM:SS"
+// form (optionally followed by milliseconds) returned by TIME_SERVER.
+func parseServerTime(value string) (int64, error) {
+	if ts, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return ts, nil
+	}
+	if len(value) > len(serverTimeLayout) {
+		value = value[:len(serverTimeLayout)]
+	}
+	t, err := time.Parse(serverTimeLayout, value)
+	if err != nil {
+		return 0, fmt.Errorf("%s wrong time: %v", MT5CommandTimeServer, err)
+	}
+	return t.Unix(), nil
+}
+
+func decodeCommon(x *Exchange) (interface{}, error) {
+	common := &CommonConfig{}
+	if err := x.Unmarshal(common); err != nil {
+		return nil, err
+	}
+	return common, nil
+}
+
+func decodeTimeConfig(x *Exchange) (interface{}, error) {
+	timeConfig := &TimeConfig{}
+	if err := x.Unmarshal(timeConfig); err != nil {
+		return nil, err
+	}
+	return timeConfig, nil
+}
+
+func decodeServerTime(x *Exchange) (interface{}, error) {
+	return parseServerTime(x.Response.Params["TIME"])
+}
@@ -0,0 +1,65 @@
+package mt5client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestServerTime(t *testing.T) {
+	s := newFakeServer(t, func(cmd *MT5Command) string {
+		switch cmd.Name {
+		case MT5CommandTimeGet:
+			return "TIME_GET|RETCODE=0 Done|\r\n" + `{"Daylight":"1","DaylightState":"1","TimeZone":"120","TimeServer":"UTC+2"}`
+		case MT5CommandTimeServer:
+			return "TIME_SERVER|RETCODE=0 Done|TIME=2021.03.04 15:16:17.123|\r\n"
+		}
+		return cmd.Name + "|RETCODE=0 Done|\r\n"
+	})
+	pool, err := New(s.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	tc, err := pool.GetTimeConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tc.Offset() != 3*time.Hour {
+		t.Errorf("offset %s, want 3h for UTC+2 with DST", tc.Offset())
+	}
+	serverTime, err := pool.GetServerTime()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2021, 3, 4, 12, 16, 17, 0, time.UTC)
+	if utc := tc.ToUTC(serverTime); !utc.Equal(want) {
+		t.Errorf("ToUTC returned %s, want %s", utc, want)
+	}
+	if utc := tc.ToUTCMsc(serverTime*1000 + 123); !utc.Equal(want.Add(123 * time.Millisecond)) {
+		t.Errorf("ToUTCMsc returned %s", utc)
+	}
+	if back := tc.FromUTC(want); back != serverTime {
+		t.Errorf("FromUTC returned %d, want %d", back, serverTime)
+	}
+}
+
+func TestParseServerTime(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+		err   bool
+	}{
+		{"1614870977", 1614870977, false},
+		{"2021.03.04 15:16:17", 1614870977, false},
+		{"2021.03.04 15:16:17.999", 1614870977, false},
+		{"04/03/2021", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseServerTime(tt.value)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("parseServerTime(%q) = %d, %v", tt.value, got, err)
+		}
+	}
+}
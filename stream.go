@@ -0,0 +1,215 @@
+package mt5client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+
+	"golang.org/x/text/transform"
+)
+
+// chunkReader reads a multi-packet response body one packet at a time, so
+// only the current chunk is held in memory.
+type chunkReader struct {
+	ctx   context.Context
+	c     *MT5Client
+	conn  net.Conn
+	buf   []byte
+	last  bool
+	stats *wireStats
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.last {
+			return 0, io.EOF
+		}
+
+		header, buf, err := r.c.readChunk(r.ctx, r.conn, r.buf[:0], r.stats)
+		if err != nil {
+			return 0, &TransportError{Err: err, Sent: true}
+		}
+		r.buf = buf
+
+		if header.bodyLen == 0 {
+			r.c.log.Debugf("#%d PING packet. Header: %+v", r.c.clientId, header)
+			continue
+		}
+		r.last = header.flag != 0x01
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// streamPayload is the payload of a streamed response decoded to UTF-8.
+type streamPayload struct {
+	io.Reader
+	cmd   *MT5Command
+	stats *wireStats
+	span  Span
+	// drained is called with the read error once the payload is drained,
+	// see measure.
+	drained func(err error)
+}
+
+// sendStreamRequest writes the request and returns the response command
+// without payload together with a reader of the payload. The payload must be
+// read to the end (see drain) before the connection is used for the next
+// request.
+func (c *MT5Client) sendStreamRequest(ctx context.Context, request []byte) (*MT5Command, *streamPayload, error) {
+	ctx, span := c.tracer.Start(ctx, "mt5client.sendRequest")
+	span.SetAttribute(AttrConn, c.clientId)
+
+	conn, err := c.activeConn()
+	if err != nil {
+		span.RecordError(err)
+		span.End()
+		return nil, nil, &TransportError{Err: err}
+	}
+
+	stats := wireStatsFrom(ctx)
+	stats.start = c.clock.Now()
+	stats.sent += len(request)
+	if err = c.write(conn, request); err != nil {
+		c.recordResult(err)
+		span.RecordError(err)
+		span.End()
+		c.log.Errorf("#%d write failed, trying reconnect %v", c.clientId, err)
+		c.startReconnect()
+		return nil, nil, &TransportError{Err: fmt.Errorf("#%d write request failed %v", c.clientId, err), Sent: true}
+	}
+
+	body := bufio.NewReader(transform.NewReader(&chunkReader{ctx: ctx, c: c, conn: conn, stats: stats}, utf16.NewDecoder()))
+
+	var command strings.Builder
+	for !strings.HasSuffix(command.String(), MT5PacketSeparator) {
+		line, err := body.ReadString(MT5PacketSeparator[len(MT5PacketSeparator)-1])
+		command.WriteString(line)
+		if err != nil {
+			c.recordResult(err)
+			span.RecordError(err)
+			span.End()
+			c.log.Errorf("#%d read response failed, trying reconnect %v", c.clientId, err)
+			c.startReconnect()
+			return nil, nil, &TransportError{Err: fmt.Errorf("error parsing body: %v", err), Sent: true}
+		}
+	}
+
+	cmd := parseCommand(strings.TrimSuffix(command.String(), MT5PacketSeparator))
+	c.logResult(cmd, c.clock.Now().Sub(stats.start))
+	return cmd, &streamPayload{Reader: body, cmd: cmd, stats: stats, span: span}, nil
+}
+
+// drain discards the rest of a streamed payload so the connection stays in
+// sync and reports the whole exchange. The connection is reconnected if that
+// fails.
+func (c *MT5Client) drain(payload *streamPayload) {
+	_, err := io.Copy(ioutil.Discard, payload)
+	c.recordResult(err)
+	if payload.drained != nil {
+		payload.drained(err)
+	}
+	if err != nil {
+		payload.span.RecordError(err)
+	}
+	traceResult(payload.span, payload.cmd, payload.stats)
+	payload.span.End()
+	if err != nil {
+		c.log.Errorf("#%d drain response failed, trying reconnect %v", c.clientId, err)
+		c.startReconnect()
+	}
+}
+
+// streamKey is the context key of the stream of a command, see exchange.
+type streamKey struct{}
+
+// stream receives the payload of a streamed command from the exchange.
+type stream struct {
+	payload *streamPayload
+}
+
+// streamItems sends a batch request and decodes the JSON array in the
+// response item by item, passing every item to m.Stream. The payload is
+// never held in memory as a whole. A read failure is a *TransportError, the
+// message is not retried once items were passed on. The stream stops when
+// the message is abandoned.
+func (c *MT5Client) streamItems(ctx context.Context, m *ClientControlMessage, spec CommandSpec) {
+	if spec.Item == nil {
+		c.respond(m, &ClientResponse{Cmd: m.Cmd, Err: fmt.Errorf("%s cannot be streamed", m.Cmd.Name)})
+		return
+	}
+
+	s := &stream{}
+	_, err := c.invoke(context.WithValue(ctx, streamKey{}, s), m.Cmd)
+
+	count := 0
+	if err == nil && s.payload == nil {
+		err = fmt.Errorf("%s response has no payload to stream", m.Cmd.Name)
+	}
+	if err == nil {
+		count, err = decodeJSONArray(s.payload, spec.Item, func(item interface{}) error {
+			if m.isAbandoned() {
+				return ErrAbandoned
+			}
+			return m.Stream(item)
+		})
+		m.streamed = count > 0
+		if err != nil {
+			err = fmt.Errorf("%s response stream error: %w", m.Cmd.Name, err)
+		}
+	}
+	if s.payload != nil {
+		c.drain(s.payload)
+	}
+
+	c.log.Debugf("#%d %s items streamed: %d", c.clientId, m.Cmd.Name, count)
+
+	c.respond(m, &ClientResponse{
+		Cmd:      m.Cmd,
+		Response: count,
+		Err:      err,
+		ClientId: c.clientId,
+	})
+}
+
+// decodeJSONArray decodes a JSON array from r one element at a time. An empty
+// payload is treated as an empty array.
+func decodeJSONArray(r io.Reader, newItem func() interface{}, fn func(item interface{}) error) (int, error) {
+	dec := json.NewDecoder(r)
+
+	tok, err := dec.Token()
+	if err == io.EOF {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return 0, fmt.Errorf("unexpected token %v, array expected", tok)
+	}
+
+	count := 0
+	for dec.More() {
+		item := newItem()
+		if err = dec.Decode(item); err != nil {
+			return count, err
+		}
+		if err = fn(item); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	if _, err = dec.Token(); err != nil {
+		return count, err
+	}
+
+	return count, nil
+}
@@ -0,0 +1,66 @@
+package mt5client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestStreamDealsBatch(t *testing.T) {
+	const total = 2000
+	deals := make([]string, 0, total)
+	for i := 1; i <= total; i++ {
+		deals = append(deals, fmt.Sprintf(`{"Deal":"%d","Login":"1000","Comment":"deal %d"}`, i, i))
+	}
+	s := newFakeServer(t, func(cmd *MT5Command) string {
+		if cmd.Name == MT5CommandDealGetBatch {
+			return "DEAL_GET_BATCH|RETCODE=0 Done|\r\n[" + strings.Join(deals, ",") + "]"
+		}
+		return cmd.Name + "|RETCODE=0 Done|\r\n{}"
+	})
+	// The batch spans tens of packets.
+	s.chunk = 2000
+	pool, err := New(s.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	var next uint64 = 1
+	err = pool.StreamDealsBatch(context.Background(), "1000", nil, nil, 0, 1, func(d *Deal) error {
+		if d.Deal != next {
+			return fmt.Errorf("deal %d after %d", d.Deal, next-1)
+		}
+		next++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next != total+1 {
+		t.Errorf("%d deals streamed, want %d", next-1, total)
+	}
+
+	// An error of fn stops the stream, the rest of the response is drained
+	// so the connection answers the next command.
+	stop := errors.New("stop")
+	n := 0
+	err = pool.StreamDealsBatch(context.Background(), "1000", nil, nil, 0, 1, func(d *Deal) error {
+		n++
+		if n == 10 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) || n != 10 {
+		t.Errorf("stream stopped after %d deals with %v, want 10 and the error of fn", n, err)
+	}
+	if _, err := pool.GetCommon(); err != nil {
+		t.Errorf("command after a stopped stream: %v", err)
+	}
+	if h := pool.Health(); h.Connections[0].Reconnects != 0 {
+		t.Errorf("%d reconnects, the stream was not drained", h.Connections[0].Reconnects)
+	}
+}
@@ -288,3 +288,36 @@ type DealerUpdates struct {
 	Result *DealerUpdatesResult `json:"result"`
 	Answer *DealerUpdatesAnswer `json:"answer"`
 }
+
+type CommonConfig struct {
+	Name               string `json:"Name"`
+	Owner              string `json:"Owner"`
+	OwnerID            string `json:"OwnerID"`
+	OwnerHost          string `json:"OwnerHost"`
+	OwnerEmail         string `json:"OwnerEmail"`
+	Product            string `json:"Product"`
+	ExpirationLicense  int64  `json:"ExpirationLicense,string"`
+	ExpirationSupport  int64  `json:"ExpirationSupport,string"`
+	LimitTradeServers  uint32 `json:"LimitTradeServers,string"`
+	LimitWebServers    uint32 `json:"LimitWebServers,string"`
+	LimitAccounts      uint32 `json:"LimitAccounts,string"`
+	LimitDeals         uint32 `json:"LimitDeals,string"`
+	LimitSymbols       uint32 `json:"LimitSymbols,string"`
+	LimitGroups        uint32 `json:"LimitGroups,string"`
+	LiveUpdateMode     uint32 `json:"LiveUpdateMode,string"`
+	TotalUsers         uint32 `json:"TotalUsers,string"`
+	TotalUsersReal     uint32 `json:"TotalUsersReal,string"`
+	TotalDeals         uint32 `json:"TotalDeals,string"`
+	TotalOrders        uint32 `json:"TotalOrders,string"`
+	TotalOrdersHistory uint32 `json:"TotalOrdersHistory,string"`
+	TotalPositions     uint32 `json:"TotalPositions,string"`
+	AccountUrl         string `json:"AccountUrl"`
+	AccountAuto        uint32 `json:"AccountAuto,string"`
+}
+
+type TimeConfig struct {
+	Daylight      uint32 `json:"Daylight,string"`
+	DaylightState uint32 `json:"DaylightState,string"`
+	TimeZone      int32  `json:"TimeZone,string"`
+	TimeServer    string `json:"TimeServer"`
+}
@@ -0,0 +1,94 @@
+package mt5client
+
+import (
+	"context"
+)
+
+// Span attributes set by the pool.
+const (
+	AttrCommand     = "mt5.command"
+	AttrLogin       = "mt5.login"
+	AttrRetcode     = "mt5.retcode"
+	AttrPayloadSize = "mt5.payload_size"
+	AttrConn        = "mt5.conn"
+	AttrAttempts    = "mt5.attempts"
+	AttrDealerID    = "mt5.dealer_id"
+	AttrSimulated   = "mt5.simulated"
+)
+
+// Tracer starts the spans of pool calls. The span is a child of the span
+// carried by ctx, if any.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a single traced operation.
+type Span interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
+
+type nopTracer struct{}
+
+type nopSpan struct{}
+
+// NopTracer creates spans that record nothing.
+func NopTracer() Tracer {
+	return nopTracer{}
+}
+
+func (nopTracer) Start(ctx context.Context, _ string) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+func (nopSpan) SetAttribute(string, interface{}) {}
+
+func (nopSpan) RecordError(error) {}
+
+func (nopSpan) End() {}
+
+// WithContext returns a shallow copy of the pool sharing its connections,
+// the spans of its calls are children of the span carried by ctx.
+func (p *Pool) WithContext(ctx context.Context) *Pool {
+	cp := *p
+	cp.ctx = ctx
+	return &cp
+}
+
+func (p *Pool) context() context.Context {
+	if p.ctx == nil {
+		return context.Background()
+	}
+	return p.ctx
+}
+
+// startCall starts the span of a pool call. The connection ends it once the
+// message has been handled.
+func (p *Pool) startCall(ctx context.Context, m *ClientControlMessage) {
+	m.parent = ctx
+	ctx, span := p.tracer.Start(ctx, "mt5client."+m.Cmd.Name)
+	span.SetAttribute(AttrCommand, m.Cmd.Name)
+	if login, ok := m.Cmd.Params["LOGIN"]; ok {
+		span.SetAttribute(AttrLogin, login)
+	}
+	span.SetAttribute(AttrPayloadSize, len(m.Cmd.Payload))
+	if m.attempt > 0 {
+		span.SetAttribute(AttrAttempts, m.attempt+1)
+	}
+	m.ctx, m.span = ctx, span
+}
+
+func (m *ClientControlMessage) context() context.Context {
+	if m == nil || m.ctx == nil {
+		return context.Background()
+	}
+	return m.ctx
+}
+
+// traceResult sets the attributes of a wire request span from its response.
+func traceResult(span Span, cmd *MT5Command, stats *wireStats) {
+	span.SetAttribute(AttrCommand, cmd.Name)
+	span.SetAttribute(AttrRetcode, cmd.Params[MT5RetCode])
+	span.SetAttribute(AttrPayloadSize, stats.received)
+}
@@ -0,0 +1,127 @@
+package mt5client
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordedSpan struct {
+	name   string
+	parent *recordedSpan
+	attrs  map[string]interface{}
+	errs   []error
+	ended  bool
+}
+
+// recordingTracer keeps every span, the parent is the span carried by the
+// context.
+type recordingTracer struct {
+	mux   sync.Mutex
+	spans []*recordedSpan
+}
+
+type spanKey struct{}
+
+func (tr *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	parent, _ := ctx.Value(spanKey{}).(*recordedSpan)
+	s := &recordedSpan{name: name, parent: parent, attrs: make(map[string]interface{})}
+	tr.mux.Lock()
+	tr.spans = append(tr.spans, s)
+	tr.mux.Unlock()
+	return context.WithValue(ctx, spanKey{}, s), &tracedSpan{tr, s}
+}
+
+// find returns the first ended span named name.
+func (tr *recordingTracer) find(name string) *recordedSpan {
+	tr.mux.Lock()
+	defer tr.mux.Unlock()
+	for _, s := range tr.spans {
+		if s.name == name && s.ended {
+			return s
+		}
+	}
+	return nil
+}
+
+type tracedSpan struct {
+	tr *recordingTracer
+	s  *recordedSpan
+}
+
+func (t *tracedSpan) SetAttribute(key string, value interface{}) {
+	t.tr.mux.Lock()
+	defer t.tr.mux.Unlock()
+	t.s.attrs[key] = value
+}
+
+func (t *tracedSpan) RecordError(err error) {
+	t.tr.mux.Lock()
+	defer t.tr.mux.Unlock()
+	t.s.errs = append(t.s.errs, err)
+}
+
+func (t *tracedSpan) End() {
+	t.tr.mux.Lock()
+	defer t.tr.mux.Unlock()
+	t.s.ended = true
+}
+
+func TestTracing(t *testing.T) {
+	s := newFakeServer(t, func(cmd *MT5Command) string {
+		if cmd.Params["LOGIN"] == "404" {
+			return cmd.Name + "|RETCODE=13 Not found|\r\n"
+		}
+		return cmd.Name + "|RETCODE=0 Done|\r\n{}"
+	})
+	tracer := &recordingTracer{}
+	pool, err := New(s.config(), WithTracer(tracer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	ctx, root := tracer.Start(context.Background(), "handler")
+	if _, err := pool.WithContext(ctx).GetCommon(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.Do(ctx, "USER_LOGINS", map[string]string{"LOGIN": "404"}, nil); err == nil {
+		t.Fatal("USER_LOGINS succeeded")
+	}
+	root.End()
+
+	var call, failed *recordedSpan
+	deadline := time.Now().Add(5 * time.Second)
+	for call == nil || failed == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("call spans not ended")
+		}
+		call, failed = tracer.find("mt5client."+MT5CommandCommonGet), tracer.find("mt5client.USER_LOGINS")
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	tracer.mux.Lock()
+	defer tracer.mux.Unlock()
+	if call.parent == nil || call.parent.name != "handler" {
+		t.Errorf("call span parent %+v, want the span of the context", call.parent)
+	}
+	if call.attrs[AttrCommand] != MT5CommandCommonGet || len(call.errs) != 0 {
+		t.Errorf("call span %+v", call)
+	}
+	if failed.attrs[AttrLogin] != "404" || len(failed.errs) == 0 {
+		t.Errorf("failed call span %+v, want the login and the error", failed)
+	}
+	wire := 0
+	for _, s := range tracer.spans {
+		if s.name == "mt5client.sendRequest" && s.parent == call {
+			wire++
+			if s.attrs[AttrRetcode] != MT5RetCodeSuccess || !s.ended {
+				t.Errorf("wire span %+v", s)
+			}
+		}
+	}
+	if wire != 1 {
+		t.Errorf("%d wire spans under the call span, want 1", wire)
+	}
+}
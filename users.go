@@ -1,9 +1,6 @@
 package mt5client
 
 import (
-	"encoding/json"
-	"errors"
-	"fmt"
 	"strconv"
 	"strings"
 	"time"
@@ -22,69 +19,46 @@ type MT5UserRequest struct {
 }
 
 func (p *Pool) GetUser(login string) (*User, error) {
-	cb := make(chan *ClientResponse)
-	defer close(cb)
-
 	params := map[string]string{
 		"LOGIN": login,
 	}
 
-	p.getClient().controlCh <- &ClientControlMessage{
+	resp, err := p.call(&ClientControlMessage{
 		Cmd: &MT5Command{
 			Name:   MT5CommandUserGet,
 			Params: params,
 		},
-		Cb: cb,
+	}, p.requestTimeout())
+	if err != nil {
+		return nil, err
 	}
-
-	resp := &ClientResponse{}
-
-	select {
-	case resp = <-cb:
-		if resp.Err != nil {
-			return nil, resp.Err
-		} else {
-			return resp.Response.(*User), resp.Err
-		}
-	case <-time.After(time.Duration(p.cfg.MT5RequestTimeout) * time.Second):
-		return nil, errors.New("timeout expired")
+	if resp.Err != nil {
+		return nil, resp.Err
 	}
+	return resp.Response.(*User), nil
 }
 
 func (p *Pool) GetUsersBatch(login []string) ([]*User, error) {
-	cb := make(chan *ClientResponse)
-	defer close(cb)
-
 	params := map[string]string{
 		"LOGIN": strings.Join(login, ","),
 	}
 
-	p.getClient().controlCh <- &ClientControlMessage{
+	resp, err := p.call(&ClientControlMessage{
 		Cmd: &MT5Command{
 			Name:   MT5CommandUserGetBatch,
 			Params: params,
 		},
-		Cb: cb,
+	}, p.requestTimeout())
+	if err != nil {
+		return nil, err
 	}
-
-	resp := &ClientResponse{}
-
-	select {
-	case resp = <-cb:
-		if resp.Err != nil {
-			return nil, resp.Err
-		} else {
-			return resp.Response.([]*User), resp.Err
-		}
-	case <-time.After(time.Duration(p.cfg.MT5RequestTimeout) * time.Second):
-		return nil, errors.New("timeout expired")
+	if resp.Err != nil {
+		return nil, resp.Err
 	}
+	return resp.Response.([]*User), nil
 }
 
 func (p *Pool) AddUser(req *MT5UserRequest) (*ClientResponse, error) {
-	cb := make(chan *ClientResponse)
-	defer close(cb)
-
 	params := map[string]string{
 		"NAME":          req.Name,
 		"PHONE":         req.Phone,
@@ -93,34 +67,27 @@ func (p *Pool) AddUser(req *MT5UserRequest) (*ClientResponse, error) {
 		"PASS_MAIN":     req.PasswordMain,
 		"PASS_INVESTOR": req.PasswordInvestor,
 		"RIGHTS":        req.Rights,
-		"EMAIL":         p.prepareEmail(req.Email),
 		"LEVERAGE":      req.Leverage,
 	}
+	if err := p.setEmail(params, req.Email); err != nil {
+		return nil, err
+	}
 
-	p.log.Debugf("MT5 ADD USER REQUEST: %+v", params)
+	p.log.Debugf("MT5 ADD USER REQUEST: %s", p.redactor.params(MT5CommandUserAdd, params))
 
-	p.getClient().controlCh <- &ClientControlMessage{
+	resp, err := p.call(&ClientControlMessage{
 		Cmd: &MT5Command{
 			Name:   MT5CommandUserAdd,
 			Params: params,
 		},
-		Cb: cb,
-	}
-
-	resp := &ClientResponse{}
-
-	select {
-	case resp = <-cb:
-		return resp, resp.Err
-	case <-time.After(time.Duration(p.cfg.MT5RequestTimeout) * time.Second):
-		return nil, errors.New("timeout expired")
+	}, p.requestTimeout())
+	if err != nil {
+		return nil, err
 	}
+	return resp, resp.Err
 }
 
 func (p *Pool) UpdateUser(req *MT5UserRequest) (*ClientResponse, error) {
-	cb := make(chan *ClientResponse)
-	defer close(cb)
-
 	params := map[string]string{
 		"NAME":          req.Name,
 		"PHONE":         req.Phone,
@@ -129,262 +96,86 @@ func (p *Pool) UpdateUser(req *MT5UserRequest) (*ClientResponse, error) {
 		"PASS_MAIN":     req.PasswordMain,
 		"PASS_INVESTOR": req.PasswordInvestor,
 		"RIGHTS":        req.Rights,
-		"EMAIL":         p.prepareEmail(req.Email),
 		"LEVERAGE":      req.Leverage,
 	}
+	if err := p.setEmail(params, req.Email); err != nil {
+		return nil, err
+	}
 
-	p.log.Debugf("MT5 UPDATE USER REQUEST: %+v", params)
+	p.log.Debugf("MT5 UPDATE USER REQUEST: %s", p.redactor.params(MT5CommandUserUpdate, params))
 
-	p.getClient().controlCh <- &ClientControlMessage{
+	resp, err := p.call(&ClientControlMessage{
 		Cmd: &MT5Command{
 			Name:   MT5CommandUserUpdate,
 			Params: params,
 		},
-		Cb: cb,
-	}
-
-	resp := &ClientResponse{}
-
-	select {
-	case resp = <-cb:
-		return resp, resp.Err
-	case <-time.After(time.Duration(p.cfg.MT5RequestTimeout) * time.Second):
-		return nil, errors.New("timeout expired")
+	}, p.requestTimeout())
+	if err != nil {
+		return nil, err
 	}
+	return resp, resp.Err
 }
 
+// DeleteUser deletes the user within the limits of the DeletePolicy of the
+// pool, see BulkDeleteUsers. USER_DELETE is sent even if the user was not
+// found, the response tells the outcome.
 func (p *Pool) DeleteUser(login string, timeout int) (*ClientResponse, error) {
-	cb := make(chan *ClientResponse)
-	defer close(cb)
-
-	p.getClient().controlCh <- &ClientControlMessage{
-		Cmd: &MT5Command{
-			Name:   MT5CommandUserDelete,
-			Params: map[string]string{"LOGIN": login},
-		},
-		Cb: cb,
-	}
-
-	resp := &ClientResponse{}
-
-	select {
-	case resp = <-cb:
-		fmt.Println(resp)
-	case <-time.After(time.Duration(timeout) * time.Second):
-		return nil, errors.New("timeout expired")
+	d := usersDelete(time.Duration(timeout) * time.Second)
+	d.sendMissing = true
+	report, err := p.bulkDelete(p.context(), d, []string{login})
+	if report == nil || report.last == nil {
+		return nil, err
 	}
-
-	return resp, nil
+	return report.last, nil
 }
 
 func (p *Pool) GetUserAccounts(login []string) (map[uint64]*UserAccount, error) {
-	cb := make(chan *ClientResponse)
-	defer close(cb)
-
 	params := map[string]string{
 		"LOGIN": strings.Join(login, ","),
 	}
 
-	p.getClient().controlCh <- &ClientControlMessage{
+	resp, err := p.call(&ClientControlMessage{
 		Cmd: &MT5Command{
 			Name:   MT5CommandUserAccountGetBatch,
 			Params: params,
 		},
-		Cb: cb,
-	}
-
-	resp := &ClientResponse{}
-
-	select {
-	case resp = <-cb:
-		if resp.Err != nil {
-			return nil, resp.Err
-		} else {
-			respUsers := resp.Response.([]*UserAccount)
-			users := make(map[uint64]*UserAccount, len(respUsers))
-			for _, u := range respUsers {
-				id, _ := strconv.ParseUint(u.Login, 10, 64)
-				users[id] = u
-			}
-			return users, resp.Err
-		}
-	case <-time.After(time.Duration(p.cfg.MT5RequestTimeout) * time.Second):
-		return nil, errors.New("timeout expired")
-	}
-}
-
-func (c *MT5Client) addUpdateUser(m *ClientControlMessage) {
-	req, body, err := c.makeRequest(m.Cmd)
-	if err != nil {
-		safeSend(m.Cb, &ClientResponse{Cmd: m.Cmd, Err: err})
-		return
-	}
-
-	c.log.Debugf("#%d %s header: %s body: %s", c.clientId, m.Cmd.Name, req[:MT5HeaderLength], body)
-
-	cmd, err := c.sendRequest(req)
-	if err != nil {
-		safeSend(m.Cb, &ClientResponse{Cmd: m.Cmd, Err: err})
-		return
-	}
-
-	if cmd.Params[MT5RetCode] != MT5RetCodeSuccess {
-		safeSend(m.Cb, &ClientResponse{Cmd: m.Cmd, Err: errors.New(cmd.Params[MT5RetCode])})
-		return
-	}
-
-	c.log.Debugf("#%d %s response: %+v", c.clientId, cmd.Name, cmd.Params)
-
-	safeSend(m.Cb, &ClientResponse{
-		Cmd:      m.Cmd,
-		Response: cmd.Payload,
-		Err:      err,
-		ClientId: c.clientId,
-	})
-}
-
-func (c *MT5Client) deleteUser(m *ClientControlMessage) {
-	req, body, err := c.makeRequest(m.Cmd)
+	}, p.requestTimeout())
 	if err != nil {
-		safeSend(m.Cb, &ClientResponse{Cmd: m.Cmd, Err: err})
-		return
+		return nil, err
 	}
-
-	c.log.Debugf("#%d %s header: %s body: %s", c.clientId, m.Cmd.Name, req[:MT5HeaderLength], body)
-
-	cmd, err := c.sendRequest(req)
-	if err != nil {
-		safeSend(m.Cb, &ClientResponse{Cmd: m.Cmd, Err: err})
-		return
+	if resp.Err != nil {
+		return nil, resp.Err
 	}
 
-	if cmd.Params[MT5RetCode] != MT5RetCodeSuccess {
-		safeSend(m.Cb, &ClientResponse{Cmd: m.Cmd, Err: fmt.Errorf("%s retcode error: %s", m.Cmd.Name, cmd.Params[MT5RetCode])})
-		return
+	respUsers := resp.Response.([]*UserAccount)
+	users := make(map[uint64]*UserAccount, len(respUsers))
+	for _, u := range respUsers {
+		id, _ := strconv.ParseUint(u.Login, 10, 64)
+		users[id] = u
 	}
-
-	c.log.Debugf("#%d %s response: %+v", c.clientId, cmd.Name, cmd.Params)
-
-	safeSend(m.Cb, &ClientResponse{
-		Cmd:      m.Cmd,
-		Response: nil,
-		Err:      err,
-		ClientId: c.clientId,
-	})
+	return users, nil
 }
 
-func (c *MT5Client) getUser(m *ClientControlMessage) {
-	req, body, err := c.makeRequest(m.Cmd)
-	if err != nil {
-		safeSend(m.Cb, &ClientResponse{Cmd: m.Cmd, Err: err})
-		return
-	}
-
-	c.log.Debugf("#%d %s header: %s body: %s", c.clientId, m.Cmd.Name, req[:MT5HeaderLength], body)
-
-	cmd, err := c.sendRequest(req)
-	if err != nil {
-		safeSend(m.Cb, &ClientResponse{Cmd: m.Cmd, Err: err})
-		return
-	}
-
-	if cmd.Params[MT5RetCode] != MT5RetCodeSuccess {
-		safeSend(m.Cb, &ClientResponse{Cmd: m.Cmd, Err: fmt.Errorf("%s retcode error: %s", m.Cmd.Name, cmd.Params[MT5RetCode])})
-		return
-	}
-
-	c.log.Debugf("#%d %s response: %+v", c.clientId, cmd.Name, cmd.Params)
-
+func decodeUser(x *Exchange) (interface{}, error) {
 	user := &User{}
-	err = json.Unmarshal([]byte(cmd.Payload), user)
-
-	safeSend(m.Cb, &ClientResponse{
-		Cmd:      m.Cmd,
-		Response: user,
-		Err:      err,
-		ClientId: c.clientId,
-	})
-}
-
-func (c *MT5Client) getUsersBatch(m *ClientControlMessage) {
-	req, body, err := c.makeRequest(m.Cmd)
-	if err != nil {
-		safeSend(m.Cb, &ClientResponse{Cmd: m.Cmd, Err: err})
-		return
-	}
-
-	c.log.Debugf("#%d %s header: %s body: %s", c.clientId, m.Cmd.Name, req[:MT5HeaderLength], body)
-
-	cmd, err := c.sendRequest(req)
-	if err != nil {
-		safeSend(m.Cb, &ClientResponse{Cmd: m.Cmd, Err: err})
-		return
+	if err := x.Unmarshal(user); err != nil {
+		return nil, err
 	}
-
-	if cmd.Params[MT5RetCode] != MT5RetCodeSuccess {
-		safeSend(m.Cb, &ClientResponse{Cmd: m.Cmd, Err: fmt.Errorf("%s retcode error: %s", m.Cmd.Name, cmd.Params[MT5RetCode])})
-		return
-	}
-
-	c.log.Debugf("#%d %s response: %+v", c.clientId, cmd.Name, cmd.Params)
-
-	users := make([]*User, 0, len(m.Cmd.Params["LOGIN"]))
-	err = json.Unmarshal([]byte(cmd.Payload), &users)
-
-	safeSend(m.Cb, &ClientResponse{
-		Cmd:      m.Cmd,
-		Response: users,
-		Err:      err,
-		ClientId: c.clientId,
-	})
+	return user, nil
 }
 
-func (c *MT5Client) getUserAccounts(m *ClientControlMessage) {
-	req, body, err := c.makeRequest(m.Cmd)
-	if err != nil {
-		safeSend(m.Cb, &ClientResponse{Cmd: m.Cmd, Err: err})
-		return
-	}
-
-	c.log.Debugf("#%d %s header: %s body: %s", c.clientId, m.Cmd.Name, req[:MT5HeaderLength], body)
-
-	cmd, err := c.sendRequest(req)
-	if err != nil {
-		safeSend(m.Cb, &ClientResponse{Cmd: m.Cmd, Err: err})
-		return
+func decodeUsers(x *Exchange) (interface{}, error) {
+	users := make([]*User, 0)
+	if err := x.Unmarshal(&users); err != nil {
+		return nil, err
 	}
-
-	if cmd.Params[MT5RetCode] != MT5RetCodeSuccess {
-		safeSend(m.Cb, &ClientResponse{Cmd: m.Cmd, Err: fmt.Errorf("%s retcode error: %s", m.Cmd.Name, cmd.Params[MT5RetCode])})
-		return
-	}
-
-	c.log.Debugf("#%d %s response: %+v", c.clientId, cmd.Name, cmd.Params)
-
-	users := make([]*UserAccount, 0, len(m.Cmd.Params["LOGIN"]))
-	err = json.Unmarshal([]byte(cmd.Payload), &users)
-
-	safeSend(m.Cb, &ClientResponse{
-		Cmd:      m.Cmd,
-		Response: users,
-		Err:      err,
-		ClientId: c.clientId,
-	})
+	return users, nil
 }
 
-func (p *Pool) prepareEmail(email string) string {
-	mask := func(value string) string {
-		m := ""
-		for i, v := range value {
-			if (i != 0 && i != len(value)-1) || (i != 0 && len(value) <= 2) {
-				m += "*"
-			} else {
-				m += fmt.Sprintf("%c", v)
-			}
-		}
-		return m
+func decodeUserAccounts(x *Exchange) (interface{}, error) {
+	accounts := make([]*UserAccount, 0)
+	if err := x.Unmarshal(&accounts); err != nil {
+		return nil, err
 	}
-	semail := strings.Split(email, "@")
-	domain := strings.Split(semail[1], ".")
-	return mask(semail[0]) + "@" + mask(domain[0]) + "." + domain[1]
+	return accounts, nil
 }